@@ -0,0 +1,155 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/dmcgowan/containerd-wasm/wasm/cache"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var precompileCommand = cli.Command{
+	Name:      "precompile",
+	Usage:     "compile modules into the cache ahead of their first container",
+	ArgsUsage: "<module|bundle>...",
+	Flags:     append([]cli.Flag{engineFlag}, configFlags...),
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("a module or bundle is required")
+		}
+		config, opts, err := loadConfig(clicontext)
+		if err != nil {
+			return err
+		}
+		c, err := wasm.OpenCache(config, opts)
+		if err != nil {
+			return err
+		}
+		ctx := context.Background()
+		for _, path := range clicontext.Args() {
+			engine, s, err := resolve(ctx, config, opts, path)
+			if err != nil {
+				return err
+			}
+			if err := wasm.Precompile(ctx, c, engine, s); err != nil {
+				return errors.Wrapf(err, "failed to precompile %s", path)
+			}
+			if s.Artifact == "" {
+				fmt.Printf("%s: %s runs modules without compiling them ahead of time\n", path, engine.Name())
+				continue
+			}
+			fmt.Printf("%s: %s\n", path, s.Artifact)
+		}
+		return nil
+	},
+}
+
+var cacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manage the cache of compiled modules",
+	Subcommands: []cli.Command{
+		{
+			Name:  "ls",
+			Usage: "list the cached artifacts",
+			Flags: configFlags,
+			Action: func(clicontext *cli.Context) error {
+				c, err := openCache(clicontext)
+				if err != nil {
+					return err
+				}
+				entries, err := c.List()
+				if err != nil {
+					return err
+				}
+				w := tabwriter.NewWriter(os.Stdout, 1, 8, 1, ' ', 0)
+				fmt.Fprintln(w, "ENGINE\tVERSION\tCONFIG\tMODULE\tSIZE\tLAST USED")
+				for _, e := range entries {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", e.Engine, e.Version, e.Config, e.Module, e.Size, e.Accessed.Format(time.RFC3339))
+				}
+				return w.Flush()
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "evict the least recently used artifacts",
+			Flags: append([]cli.Flag{
+				cli.Int64Flag{
+					Name:  "max-size",
+					Usage: "bytes to keep, defaults to the cache_max_size of the config, without which nothing is pruned",
+					Value: -1,
+				},
+			}, configFlags...),
+			Action: func(clicontext *cli.Context) error {
+				config, opts, err := loadConfig(clicontext)
+				if err != nil {
+					return err
+				}
+				c, err := wasm.OpenCache(config, opts)
+				if err != nil {
+					return err
+				}
+				max := clicontext.Int64("max-size")
+				if max < 0 {
+					if config.CacheMaxSize == 0 {
+						// the cache has no budget to bring it within
+						fmt.Println("no cache_max_size configured and no --max-size given, nothing to prune")
+						return nil
+					}
+					max = config.CacheMaxSize
+				}
+				evicted, err := c.Prune(max)
+				printEntries("evicted", evicted)
+				return err
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "check the digest of every artifact, removing corrupt ones",
+			Flags: configFlags,
+			Action: func(clicontext *cli.Context) error {
+				c, err := openCache(clicontext)
+				if err != nil {
+					return err
+				}
+				corrupt, err := c.Verify()
+				printEntries("removed corrupt", corrupt)
+				return err
+			},
+		},
+	},
+}
+
+func openCache(clicontext *cli.Context) (*cache.Cache, error) {
+	config, opts, err := loadConfig(clicontext)
+	if err != nil {
+		return nil, err
+	}
+	return wasm.OpenCache(config, opts)
+}
+
+func printEntries(what string, entries []cache.Entry) {
+	for _, e := range entries {
+		fmt.Printf("%s %s %s %s\n", what, e.Engine, e.Version, e.Module)
+	}
+}
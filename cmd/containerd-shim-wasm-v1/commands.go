@@ -0,0 +1,119 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Name = "containerd-shim-wasm-v1"
+	app.Usage = "containerd shim running wasm modules"
+	app.HideVersion = true
+	app.Commands = []cli.Command{
+		precompileCommand,
+		cacheCommand,
+		inspectCommand,
+		runCommand,
+	}
+	return app
+}
+
+// configFlags select the configuration a command loads, as the shim would
+var configFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "node configuration file, defaults to $" + wasm.ConfigEnv + " or " + wasm.DefaultConfigPath,
+	},
+	cli.StringFlag{
+		Name:  "options",
+		Usage: "runtime options file, as named by the CRI runtime ConfigPath",
+	},
+}
+
+// engineFlag overrides the engine selected by the options and config
+var engineFlag = cli.StringFlag{
+	Name:  "engine",
+	Usage: "engine to use, overriding the options and config",
+}
+
+// loadConfig loads the node configuration and runtime options
+func loadConfig(clicontext *cli.Context) (*wasm.Config, *options.Options, error) {
+	if path := clicontext.String("config"); path != "" {
+		os.Setenv(wasm.ConfigEnv, path)
+	}
+	config, err := wasm.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the shim logs each step at info, which is noise on a terminal
+	level := logrus.WarnLevel
+	if config.LogLevel != "" {
+		level, _ = logrus.ParseLevel(config.LogLevel)
+	}
+	logrus.SetLevel(level)
+	opts := &options.Options{}
+	if path := clicontext.String("options"); path != "" {
+		if opts, err = options.Load(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	if engine := clicontext.String("engine"); engine != "" {
+		opts.Engine = engine
+	}
+	return config, opts, nil
+}
+
+// resolve the module invocation for a bundle directory or a module file,
+// which is exposed to itself as the only file in its root
+func resolve(ctx context.Context, config *wasm.Config, opts *options.Options, path string) (wasm.Engine, *wasm.Spec, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fi.IsDir() {
+		spec := &specs.Spec{
+			Process: &specs.Process{
+				Args: []string{"/" + filepath.Base(path)},
+			},
+		}
+		return wasm.ResolveSpec(ctx, config, fi.Name(), "", filepath.Dir(path), spec, opts)
+	}
+	spec, err := wasm.ReadSpec(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "bundle %s", path)
+	}
+	rootfs, err := wasm.SpecRootfs(path, spec)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "bundle %s", path)
+	}
+	return wasm.ResolveSpec(ctx, config, filepath.Base(path), path, rootfs, spec, opts)
+}
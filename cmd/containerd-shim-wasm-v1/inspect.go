@@ -0,0 +1,189 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/dmcgowan/containerd-wasm/wasm/module"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "describe a module and how the shim would run it",
+	ArgsUsage: "<module|bundle>",
+	Flags: append([]cli.Flag{
+		engineFlag,
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print JSON",
+		},
+	}, configFlags...),
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() != 1 {
+			return errors.New("a module or bundle is required")
+		}
+		config, opts, err := loadConfig(clicontext)
+		if err != nil {
+			return err
+		}
+		path := clicontext.Args().First()
+		guest, host, err := findModule(path)
+		if err != nil {
+			return err
+		}
+		m, err := module.Open(host)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse %s", host)
+		}
+		i := &inspection{
+			Path:           host,
+			Module:         guest,
+			WASI:           m.WASI(),
+			Type:           "reactor",
+			Imports:        m.Imports,
+			Exports:        m.Exports,
+			Memories:       m.Memories,
+			CustomSections: m.CustomSections,
+		}
+		if m.IsCommand() {
+			i.Type = "command"
+		}
+		i.Engine = &engineInspection{Name: opts.Engine}
+		engine, s, err := resolve(context.Background(), config, opts, path)
+		if err != nil {
+			i.Engine.Error = err.Error()
+		} else {
+			i.Engine.Name = engine.Name()
+			i.Engine.Version = s.Capabilities.Version
+			i.Engine.Command = wasm.CommandLine(engine, s)
+		}
+
+		if clicontext.Bool("json") {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(i)
+		}
+		i.print()
+		return nil
+	},
+}
+
+type inspection struct {
+	// Path of the module on the host
+	Path string `json:"path"`
+	// Module is the guest path of the module
+	Module         string                 `json:"module"`
+	WASI           string                 `json:"wasi,omitempty"`
+	Type           string                 `json:"type"`
+	Imports        []module.Import        `json:"imports"`
+	Exports        []module.Export        `json:"exports"`
+	Memories       []module.Memory        `json:"memories"`
+	CustomSections []module.CustomSection `json:"custom_sections"`
+	Engine         *engineInspection      `json:"engine"`
+}
+
+type engineInspection struct {
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Command line of engines running modules in a child process
+	Command []string `json:"command,omitempty"`
+	// Error preventing the module from running on the engine
+	Error string `json:"error,omitempty"`
+}
+
+// findModule returns the guest and host path of the module in a bundle,
+// or of the module file itself
+func findModule(path string) (string, string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if !fi.IsDir() {
+		return "/" + fi.Name(), path, nil
+	}
+	spec, err := wasm.ReadSpec(path)
+	if err != nil {
+		return "", "", err
+	}
+	rootfs, err := wasm.SpecRootfs(path, spec)
+	if err != nil {
+		return "", "", err
+	}
+	return wasm.FindModule(rootfs, spec)
+}
+
+func (i *inspection) print() {
+	wasi := i.WASI
+	if wasi == "" {
+		wasi = "none"
+	}
+	fmt.Printf("Module:  %s (%s)\n", i.Module, i.Path)
+	fmt.Printf("Type:    %s\n", i.Type)
+	fmt.Printf("WASI:    %s\n", wasi)
+
+	fmt.Printf("Imports:\n")
+	for _, imp := range i.Imports {
+		if imp.Memory != nil {
+			fmt.Printf("  %s %s\n", imp, limits(*imp.Memory))
+			continue
+		}
+		fmt.Printf("  %s\n", imp)
+	}
+	fmt.Printf("Exports:\n")
+	for _, e := range i.Exports {
+		fmt.Printf("  %s (%s)\n", e.Name, e.Kind)
+	}
+	fmt.Printf("Memories:\n")
+	for _, m := range i.Memories {
+		fmt.Printf("  %s\n", limits(m))
+	}
+	fmt.Printf("Custom sections:\n")
+	for _, c := range i.CustomSections {
+		fmt.Printf("  %s (%d bytes)\n", c.Name, c.Size)
+	}
+
+	fmt.Printf("Engine:  %s %s\n", i.Engine.Name, i.Engine.Version)
+	switch {
+	case i.Engine.Error != "":
+		fmt.Printf("  error: %s\n", i.Engine.Error)
+	case i.Engine.Command != nil:
+		fmt.Printf("  command: %s\n", strings.Join(i.Engine.Command, " "))
+	default:
+		fmt.Printf("  runs the module inside the shim\n")
+	}
+}
+
+// limits formats memory limits in pages
+func limits(m module.Memory) string {
+	max := "unbounded"
+	if m.HasMax {
+		max = fmt.Sprint(m.Max)
+	}
+	s := fmt.Sprintf("min %d, max %s pages", m.Min, max)
+	if m.Shared {
+		s += ", shared"
+	}
+	return s
+}
@@ -17,10 +17,22 @@ package main
 */
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/containerd/containerd/runtime/v2/shim"
 	wasm "github.com/dmcgowan/containerd-wasm"
 )
 
 func main() {
+	// containerd always passes flags first, a leading command name is
+	// an operator running one of the commands outside of the shim protocol
+	if app := newApp(); len(os.Args) > 1 && app.Command(os.Args[1]) != nil {
+		if err := app.Run(os.Args); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", app.Name, err)
+			os.Exit(1)
+		}
+		return
+	}
 	shim.Run("io.containerd.wasm.v1", wasm.New)
 }
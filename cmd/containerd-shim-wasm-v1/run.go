@@ -0,0 +1,241 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/fifo"
+	"github.com/containerd/typeurl"
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var runCommand = cli.Command{
+	Name:  "run",
+	Usage: "run an OCI bundle without containerd, printing its events",
+	Description: `Creates, starts and waits for the container of a bundle through the same
+code as the shim. The root filesystem is the root path of the bundle's spec.
+The module's stdio is connected to the command's through fifos and each task
+event is printed to stdout as a JSON line. The command exits with the exit status of the module.`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Usage: "path to the bundle",
+			Value: ".",
+		},
+		cli.StringFlag{
+			Name:  "id",
+			Usage: "container id, defaults to the bundle directory name",
+		},
+		engineFlag,
+	}, configFlags...),
+	Action: func(clicontext *cli.Context) error {
+		config, opts, err := loadConfig(clicontext)
+		if err != nil {
+			return err
+		}
+		bundle, err := filepath.Abs(clicontext.String("bundle"))
+		if err != nil {
+			return err
+		}
+		id := clicontext.String("id")
+		if id == "" {
+			id = filepath.Base(bundle)
+		}
+		any, err := typeurl.MarshalAny(opts)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		sio, err := newFifoIO(ctx)
+		if err != nil {
+			return err
+		}
+		defer sio.Close()
+		ec := make(chan wasm.Exit, 1)
+		r := &task.CreateTaskRequest{
+			ID:      id,
+			Bundle:  bundle,
+			Stdin:   sio.stdin,
+			Stdout:  sio.stdout,
+			Stderr:  sio.stderr,
+			Options: any,
+		}
+		container, err := wasm.NewContainer(ctx, nil, config, r, ec)
+		if err != nil {
+			return errors.Wrap(err, "create")
+		}
+		printEvent(&eventstypes.TaskCreate{
+			ContainerID: id,
+			Bundle:      bundle,
+			IO: &eventstypes.TaskIO{
+				Stdin:  r.Stdin,
+				Stdout: r.Stdout,
+				Stderr: r.Stderr,
+			},
+			Pid: uint32(container.Pid()),
+		}, "")
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		p, err := container.Start(ctx, &task.StartRequest{ID: id})
+		if err != nil {
+			return errors.Wrap(err, "start")
+		}
+		printEvent(&eventstypes.TaskStart{
+			ContainerID: id,
+			Pid:         uint32(p.Pid()),
+		}, "")
+
+		var e wasm.Exit
+	wait:
+		for {
+			select {
+			case s := <-signals:
+				if err := container.Kill(ctx, &task.KillRequest{ID: id, Signal: uint32(s.(syscall.Signal))}); err != nil {
+					logrus.WithError(err).Warnf("failed to forward %v", s)
+				}
+			case e = <-ec:
+				break wait
+			}
+		}
+		p.SetExited(e.Status)
+		sio.Wait()
+		if e.Reason == wasm.ExitReasonMemoryExhausted {
+			printEvent(&eventstypes.TaskOOM{
+				ContainerID: id,
+			}, "")
+		}
+		printEvent(&eventstypes.TaskExit{
+			ContainerID: id,
+			ID:          id,
+			Pid:         uint32(e.Pid),
+			ExitStatus:  uint32(e.Status),
+			ExitedAt:    p.ExitedAt(),
+		}, e.Reason)
+
+		if _, err := container.Delete(ctx, &task.DeleteRequest{ID: id}); err != nil {
+			return errors.Wrap(err, "delete")
+		}
+		printEvent(&eventstypes.TaskDelete{
+			ContainerID: id,
+			Pid:         uint32(e.Pid),
+			ExitStatus:  uint32(e.Status),
+			ExitedAt:    p.ExitedAt(),
+		}, "")
+		if e.Status != 0 {
+			return cli.NewExitError("", e.Status)
+		}
+		return nil
+	},
+}
+
+// fifoIO connects the stdio of the command to a container through fifos,
+// as containerd does for its tasks
+type fifoIO struct {
+	dir    string
+	stdin  string
+	stdout string
+	stderr string
+	wg     sync.WaitGroup
+}
+
+func newFifoIO(ctx context.Context) (_ *fifoIO, err error) {
+	dir, err := ioutil.TempDir("", "containerd-shim-wasm-run-")
+	if err != nil {
+		return nil, err
+	}
+	f := &fifoIO{
+		dir:    dir,
+		stdin:  filepath.Join(dir, "stdin"),
+		stdout: filepath.Join(dir, "stdout"),
+		stderr: filepath.Join(dir, "stderr"),
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+	in, err := fifo.OpenFifo(ctx, f.stdin, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_NONBLOCK, 0700)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		// closing the fifo before the container opened it would leave
+		// the container waiting for a writer, an empty write waits for it
+		if _, err := in.Write(nil); err == nil {
+			io.Copy(in, os.Stdin)
+		}
+		in.Close()
+	}()
+	for path, w := range map[string]io.Writer{f.stdout: os.Stdout, f.stderr: os.Stderr} {
+		out, err := fifo.OpenFifo(ctx, path, syscall.O_RDONLY|syscall.O_CREAT|syscall.O_NONBLOCK, 0700)
+		if err != nil {
+			return nil, err
+		}
+		f.wg.Add(1)
+		go func(w io.Writer) {
+			defer f.wg.Done()
+			io.Copy(w, out)
+			out.Close()
+		}(w)
+	}
+	return f, nil
+}
+
+// Wait for the output of the container to be copied
+func (f *fifoIO) Wait() {
+	f.wg.Wait()
+}
+
+// Close removes the fifos
+func (f *fifoIO) Close() error {
+	return os.RemoveAll(f.dir)
+}
+
+// event is printed for each task event, as published by the shim
+type event struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Topic     string      `json:"topic"`
+	Event     interface{} `json:"event"`
+	// Reason the module exited for, on exit events
+	Reason string `json:"reason,omitempty"`
+}
+
+func printEvent(e interface{}, reason string) {
+	json.NewEncoder(os.Stdout).Encode(&event{
+		Timestamp: time.Now(),
+		Topic:     wasm.GetTopic(e),
+		Event:     e,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var startCommand = cli.Command{
+	Name:      "start",
+	Usage:     "start a created container",
+	ArgsUsage: "<container-id>",
+	Action: func(clicontext *cli.Context) error {
+		id, dir, err := containerDir(clicontext)
+		if err != nil {
+			return err
+		}
+		st, err := loadState(dir)
+		if err != nil {
+			return err
+		}
+		if status := st.status(dir); status != statusCreated {
+			return errors.Errorf("cannot start container %s in %s state", id, status)
+		}
+		// init is blocked opening the fifo for writing, so it counts as a
+		// writer and reading only returns early if it died
+		path := filepath.Join(dir, execFifo)
+		f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := unix.SetNonblock(int(f.Fd()), false); err != nil {
+			return err
+		}
+		b := make([]byte, 1)
+		if n, err := f.Read(b); n == 0 {
+			return errors.Wrapf(err, "container %s exited before it was started", id)
+		}
+		return os.Remove(path)
+	},
+}
+
+var stateCommand = cli.Command{
+	Name:      "state",
+	Usage:     "print the state of a container as JSON",
+	ArgsUsage: "<container-id>",
+	Action: func(clicontext *cli.Context) error {
+		_, dir, err := containerDir(clicontext)
+		if err != nil {
+			return err
+		}
+		st, err := loadState(dir)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st.oci(dir))
+	},
+}
+
+var killCommand = cli.Command{
+	Name:      "kill",
+	Usage:     "signal the process of a container",
+	ArgsUsage: "<container-id> [signal]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "all, a",
+			Usage: "accepted for compatibility with runc, containers run a single process",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		id, dir, err := containerDir(clicontext)
+		if err != nil {
+			return err
+		}
+		st, err := loadState(dir)
+		if err != nil {
+			return err
+		}
+		sig := unix.SIGTERM
+		if s := clicontext.Args().Get(1); s != "" {
+			if sig, err = parseSignal(s); err != nil {
+				return err
+			}
+		}
+		if st.status(dir) == statusStopped {
+			return errors.Errorf("container %s is not running", id)
+		}
+		return unix.Kill(st.Pid, sig)
+	},
+}
+
+// deleteTimeout is how long delete --force waits for a killed container
+const deleteTimeout = 10 * time.Second
+
+var deleteCommand = cli.Command{
+	Name:      "delete",
+	Usage:     "delete a stopped container",
+	ArgsUsage: "<container-id>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f",
+			Usage: "kill the container if it is still running",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		id, dir, err := containerDir(clicontext)
+		if err != nil {
+			return err
+		}
+		st, err := loadState(dir)
+		if err != nil {
+			if clicontext.Bool("force") {
+				return os.RemoveAll(dir)
+			}
+			return err
+		}
+		if st.status(dir) != statusStopped {
+			if !clicontext.Bool("force") {
+				return errors.Errorf("cannot delete container %s that is not stopped", id)
+			}
+			if err := unix.Kill(st.Pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+				return err
+			}
+			deadline := time.Now().Add(deleteTimeout)
+			for st.status(dir) != statusStopped {
+				if time.Now().After(deadline) {
+					return errors.Errorf("container %s did not exit after SIGKILL", id)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if err := wasm.UnmountPreopens(st.Bundle); err != nil {
+			return err
+		}
+		if err := wasm.DeleteCgroup(st.CgroupsPath); err != nil {
+			return err
+		}
+		return os.RemoveAll(dir)
+	},
+}
+
+var featuresCommand = cli.Command{
+	Name:  "features",
+	Usage: "print the features of the runtime as JSON",
+	Action: func(clicontext *cli.Context) error {
+		features := struct {
+			OCIVersionMin string            `json:"ociVersionMin"`
+			OCIVersionMax string            `json:"ociVersionMax"`
+			Hooks         []string          `json:"hooks"`
+			MountOptions  []string          `json:"mountOptions"`
+			Annotations   map[string]string `json:"annotations"`
+		}{
+			OCIVersionMin: "1.0.0",
+			OCIVersionMax: specs.Version,
+			Hooks:         []string{},
+			MountOptions:  []string{},
+			Annotations: map[string]string{
+				"io.containerd.wasm.engines": strings.Join(wasm.Engines(), ","),
+			},
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(&features)
+	},
+}
@@ -0,0 +1,273 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var createCommand = cli.Command{
+	Name:      "create",
+	Usage:     "create a container",
+	ArgsUsage: "<container-id>",
+	Description: `Creates the container of a bundle and leaves it waiting for start. The
+container's process inherits the stdio of create.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Usage: "path to the bundle",
+			Value: ".",
+		},
+		cli.StringFlag{
+			Name:  "pid-file",
+			Usage: "file to write the process id to",
+		},
+		cli.StringFlag{
+			Name:  "console-socket",
+			Usage: "not supported, modules cannot run on a terminal",
+		},
+		cli.BoolFlag{
+			Name:  "no-pivot",
+			Usage: "accepted for compatibility with runc",
+		},
+		cli.BoolFlag{
+			Name:  "no-new-keyring",
+			Usage: "accepted for compatibility with runc",
+		},
+		cli.IntFlag{
+			Name:  "preserve-fds",
+			Usage: "accepted for compatibility with runc",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		id, dir, err := containerDir(clicontext)
+		if err != nil {
+			return err
+		}
+		bundle, err := filepath.Abs(clicontext.String("bundle"))
+		if err != nil {
+			return err
+		}
+		spec, err := wasm.ReadSpec(bundle)
+		if err != nil {
+			return err
+		}
+		if spec.Process.Terminal || clicontext.String("console-socket") != "" {
+			return errors.New("terminals are not supported")
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0711); err != nil {
+			return err
+		}
+		if err := os.Mkdir(dir, 0711); err != nil {
+			if os.IsExist(err) {
+				return errors.Errorf("container with id %s already exists", id)
+			}
+			return err
+		}
+		if err := create(clicontext, id, dir, bundle); err != nil {
+			wasm.UnmountPreopens(bundle)
+			os.RemoveAll(dir)
+			return err
+		}
+		return nil
+	},
+}
+
+// create starts the init process of the container and waits for it to be
+// ready to start the module
+func create(clicontext *cli.Context, id, dir, bundle string) error {
+	if err := unix.Mkfifo(filepath.Join(dir, execFifo), 0600); err != nil {
+		return errors.Wrap(err, "failed to create exec fifo")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(self, "--root", clicontext.GlobalString("root"), "init", "--bundle", bundle, id)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = bundle
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to start init process")
+	}
+	// init closes its end once the container is created, reporting any
+	// error on the way
+	msg, err := ioutil.ReadAll(r)
+	if err == nil && len(msg) > 0 {
+		err = errors.New(string(msg))
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if path := clicontext.String("pid-file"); path != "" {
+		if err := writePidFile(path, cmd.Process.Pid); err != nil {
+			return err
+		}
+	}
+	return cmd.Process.Release()
+}
+
+// initCommand is run by create as the process of the container
+var initCommand = cli.Command{
+	Name:   "init",
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name: "bundle",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		ready := os.NewFile(3, "ready")
+		engine, s, err := initContainer(clicontext)
+		if err != nil {
+			fmt.Fprint(ready, err.Error())
+			ready.Close()
+			return cli.NewExitError("", 1)
+		}
+		ready.Close()
+
+		_, dir, _ := containerDir(clicontext)
+		if err := waitStart(dir); err != nil {
+			return err
+		}
+		signals := make(chan os.Signal, 32)
+		signal.Notify(signals)
+		instance, err := engine.Start(context.Background(), s, wasm.IO{
+			Stdin:  os.Stdin,
+			Stdout: os.Stdout,
+			Stderr: os.Stderr,
+		})
+		if err != nil {
+			return err
+		}
+		go func() {
+			for sig := range signals {
+				switch sig {
+				case unix.SIGCHLD, unix.SIGURG, unix.SIGPIPE:
+					continue
+				}
+				instance.Signal(sig.(syscall.Signal))
+			}
+		}()
+		status, err := instance.Wait()
+		if err != nil {
+			return err
+		}
+		return cli.NewExitError("", status)
+	},
+}
+
+// initContainer prepares the container and records its state
+func initContainer(clicontext *cli.Context) (wasm.Engine, *wasm.Spec, error) {
+	id, dir, err := containerDir(clicontext)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle := clicontext.String("bundle")
+	config, err := wasm.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := wasm.ReadSpec(bundle)
+	if err != nil {
+		return nil, nil, err
+	}
+	rootfs, err := wasm.SpecRootfs(bundle, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	engine, s, err := wasm.CreateSpec(context.Background(), config, id, bundle, rootfs, spec, &options.Options{})
+	if err != nil {
+		return nil, nil, err
+	}
+	// init is the process of the container, the engine inherits its cgroup
+	cg, err := wasm.NewCgroup(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cg != nil {
+		if err := wasm.AddToCgroup(cg, os.Getpid()); err != nil {
+			return nil, nil, err
+		}
+	}
+	startTime, err := processStartTime(os.Getpid())
+	if err != nil {
+		return nil, nil, err
+	}
+	st := &state{
+		ID:          id,
+		Bundle:      bundle,
+		Pid:         os.Getpid(),
+		StartTime:   startTime,
+		Created:     time.Now().UTC(),
+		Annotations: spec.Annotations,
+	}
+	if spec.Linux != nil {
+		st.CgroupsPath = spec.Linux.CgroupsPath
+	}
+	if err := st.save(dir); err != nil {
+		return nil, nil, err
+	}
+	return engine, s, nil
+}
+
+// waitStart blocks until start opens the exec fifo
+func waitStart(dir string) error {
+	f, err := os.OpenFile(filepath.Join(dir, execFifo), os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to open exec fifo")
+	}
+	defer f.Close()
+	_, err = f.Write([]byte{0})
+	return err
+}
+
+func writePidFile(path string, pid int) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path))
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,108 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dmcgowan/containerd-wasm/wasm"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// defaultRoot holds the state of containers
+const defaultRoot = "/run/runwasm"
+
+// runwasm is an OCI runtime running wasm containers with the engines of the
+// wasm shim. Its commands and flags follow runc so it can be used by
+// CRI-O, podman and other runc compatible managers.
+func main() {
+	app := cli.NewApp()
+	app.Name = "runwasm"
+	app.Usage = "OCI runtime running wasm modules"
+	app.Version = fmt.Sprintf("%s\nspec: %s", "0.1.0", specs.Version)
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "root",
+			Usage: "root directory for storage of container state",
+			Value: defaultRoot,
+		},
+		cli.StringFlag{
+			Name:  "log",
+			Usage: "log file, defaults to stderr",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Usage: "log format, text or json",
+			Value: "text",
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "enable debug output in logs",
+		},
+		cli.BoolFlag{
+			Name:  "systemd-cgroup",
+			Usage: "accepted for compatibility with runc, cgroups paths in slice:prefix:name form are always systemd's",
+		},
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "node configuration file, defaults to $" + wasm.ConfigEnv + " or " + wasm.DefaultConfigPath,
+		},
+	}
+	app.Commands = []cli.Command{
+		createCommand,
+		initCommand,
+		startCommand,
+		stateCommand,
+		killCommand,
+		deleteCommand,
+		featuresCommand,
+	}
+	app.Before = func(clicontext *cli.Context) error {
+		if path := clicontext.GlobalString("config"); path != "" {
+			os.Setenv(wasm.ConfigEnv, path)
+		}
+		logrus.SetLevel(logrus.WarnLevel)
+		if clicontext.GlobalBool("debug") {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		if path := clicontext.GlobalString("log"); path != "" {
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			logrus.SetOutput(f)
+		}
+		switch format := clicontext.GlobalString("log-format"); format {
+		case "text":
+		case "json":
+			logrus.SetFormatter(&logrus.JSONFormatter{})
+		default:
+			return fmt.Errorf("unknown log format %q", format)
+		}
+		return nil
+	}
+	if err := app.Run(os.Args); err != nil {
+		if logrus.StandardLogger().Out != os.Stderr {
+			logrus.Error(err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", app.Name, err)
+		os.Exit(1)
+	}
+}
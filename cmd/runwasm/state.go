@@ -0,0 +1,177 @@
+package main
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	stateFile = "state.json"
+	// execFifo blocks the init process of a created container until start
+	execFifo = "exec.fifo"
+)
+
+// container statuses as defined by the runtime spec
+const (
+	statusCreated = "created"
+	statusRunning = "running"
+	statusStopped = "stopped"
+)
+
+// state of a container, persisted under the root directory
+type state struct {
+	ID     string `json:"id"`
+	Bundle string `json:"bundle"`
+	// Pid of the init process running the container
+	Pid int `json:"pid"`
+	// StartTime of the init process, guarding against pid reuse
+	StartTime string `json:"start_time"`
+	// CgroupsPath of the spec, the cgroup is removed on delete
+	CgroupsPath string            `json:"cgroups_path,omitempty"`
+	Created     time.Time         `json:"created"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// containerDir returns the state directory of the container named by the
+// first argument
+func containerDir(clicontext *cli.Context) (string, string, error) {
+	id := clicontext.Args().First()
+	if id == "" {
+		return "", "", errors.New("container id cannot be empty")
+	}
+	if id == "." || id == ".." || strings.ContainsAny(id, "/\x00") {
+		return "", "", errors.Errorf("invalid container id %q", id)
+	}
+	root, err := filepath.Abs(clicontext.GlobalString("root"))
+	if err != nil {
+		return "", "", err
+	}
+	return id, filepath.Join(root, id), nil
+}
+
+func loadState(dir string) (*state, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("container %s does not exist", filepath.Base(dir))
+		}
+		return nil, err
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, errors.Wrapf(err, "invalid state of container %s", filepath.Base(dir))
+	}
+	return &s, nil
+}
+
+func (s *state) save(dir string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".state-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, stateFile))
+}
+
+// status of the container: created until start opened the exec fifo,
+// running while the init process lives and stopped afterwards
+func (s *state) status(dir string) string {
+	if startTime, err := processStartTime(s.Pid); err != nil || startTime != s.StartTime {
+		return statusStopped
+	}
+	if _, err := os.Stat(filepath.Join(dir, execFifo)); err == nil {
+		return statusCreated
+	}
+	return statusRunning
+}
+
+// oci returns the state as defined by the runtime spec
+func (s *state) oci(dir string) *specs.State {
+	st := &specs.State{
+		Version:     specs.Version,
+		ID:          s.ID,
+		Status:      s.status(dir),
+		Bundle:      s.Bundle,
+		Annotations: s.Annotations,
+	}
+	if st.Status != statusStopped {
+		st.Pid = s.Pid
+	}
+	return st
+}
+
+// processStartTime returns the start time of a process in clock ticks
+// since boot, zombies count as exited
+func processStartTime(pid int) (string, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+	// the command name may contain spaces, fields are counted after it
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	if len(fields) < 20 {
+		return "", errors.Errorf("invalid stat of process %d", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return "", errors.Errorf("process %d exited", pid)
+	}
+	return fields[19], nil
+}
+
+// parseSignal parses a signal by number or name, with or without SIG
+func parseSignal(s string) (unix.Signal, error) {
+	var n int
+	if _, err := fmt.Sscanf(s, "%d", &n); err == nil && fmt.Sprint(n) == s {
+		if unix.SignalName(unix.Signal(n)) == "" {
+			return 0, errors.Errorf("unknown signal %q", s)
+		}
+		return unix.Signal(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return 0, errors.Errorf("unknown signal %q", s)
+	}
+	return sig, nil
+}
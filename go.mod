@@ -1,9 +1,9 @@
 module github.com/dmcgowan/containerd-wasm
 
-go 1.14
+go 1.20
 
 require (
-	github.com/Microsoft/hcsshim v0.8.7 // indirect
+	github.com/BurntSushi/toml v0.3.1
 	github.com/containerd/cgroups v0.0.0-20200404012852-53ba5634dc0f
 	github.com/containerd/console v1.0.0
 	github.com/containerd/containerd v1.3.3
@@ -11,10 +11,28 @@ require (
 	github.com/containerd/fifo v0.0.0-20191213151349-ff969a566b00
 	github.com/containerd/typeurl v1.0.0
 	github.com/gogo/protobuf v1.3.1
-	github.com/opencontainers/go-digest v1.0.0-rc1.0.20180430190053-c9281466c8b2 // indirect
-	github.com/opencontainers/runc v1.0.0-rc8 // indirect
+	github.com/opencontainers/go-digest v1.0.0-rc1.0.20180430190053-c9281466c8b2
 	github.com/opencontainers/runtime-spec v1.0.2
 	github.com/pkg/errors v0.9.1
 	github.com/sirupsen/logrus v1.5.0
+	github.com/tetratelabs/wazero v1.7.3
+	github.com/urfave/cli v1.22.2
 	golang.org/x/sys v0.0.0-20200331124033-c3d80250170d
 )
+
+require (
+	github.com/Microsoft/hcsshim v0.8.7 // indirect
+	github.com/cilium/ebpf v0.0.0-20200110133405-4032b1d8aae3 // indirect
+	github.com/containerd/go-runc v0.0.0-20180907222934-5a6d9f37cfa3 // indirect
+	github.com/containerd/ttrpc v0.0.0-20190828154514-0e0f228740de // indirect
+	github.com/coreos/go-systemd/v22 v22.0.0 // indirect
+	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
+	github.com/docker/go-units v0.4.0 // indirect
+	github.com/godbus/dbus/v5 v5.0.3 // indirect
+	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/opencontainers/runc v1.0.0-rc8 // indirect
+	github.com/russross/blackfriday/v2 v2.0.1 // indirect
+	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
+	google.golang.org/genproto v0.0.0-20190425155659-357c62f0e4bb // indirect
+	google.golang.org/grpc v1.20.1 // indirect
+)
@@ -29,8 +29,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/containerd/cgroups"
-	cgroupsv2 "github.com/containerd/cgroups/v2"
 	eventstypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/errdefs"
@@ -68,6 +66,14 @@ type spec struct {
 
 // New returns a new shim service that can be used via GRPC
 func New(ctx context.Context, id string, publisher shim.Publisher, cancel func()) (shim.Shim, error) {
+	config, err := wasm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.LogLevel != "" {
+		level, _ := logrus.ParseLevel(config.LogLevel)
+		logrus.SetLevel(level)
+	}
 	ep, err := wasm.NewOOMEpoller(publisher)
 	if err != nil {
 		return nil, err
@@ -75,12 +81,14 @@ func New(ctx context.Context, id string, publisher shim.Publisher, cancel func()
 	go ep.Run(ctx)
 	s := &service{
 		id:         id,
+		config:     config,
 		context:    ctx,
 		events:     make(chan interface{}, 128),
 		ec:         make(chan wasm.Exit),
 		ep:         ep,
 		cancel:     cancel,
 		containers: make(map[string]*wasm.Container),
+		creating:   make(map[string]struct{}),
 		log:        log.GetLogger(context.TODO()),
 	}
 	go s.processExits()
@@ -97,6 +105,7 @@ type service struct {
 	mu          sync.Mutex
 	eventSendMu sync.Mutex
 
+	config   *wasm.Config
 	context  context.Context
 	events   chan interface{}
 	platform stdio.Platform
@@ -108,6 +117,9 @@ type service struct {
 	id string
 
 	containers map[string]*wasm.Container
+	// creating holds the ids of containers being created outside of mu,
+	// which count against the instance limit
+	creating map[string]struct{}
 
 	cancel func()
 }
@@ -214,14 +226,28 @@ func (s *service) StartShim(ctx context.Context, id, containerdBinary, container
 func (s *service) Cleanup(ctx context.Context) (*taskAPI.DeleteResponse, error) {
 	s.log.Info("wasm Cleanup")
 
-	// Unmount rootfs mounts
 	pwd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
+
+	// Kill the engine processes left in the container's cgroup, which
+	// may hold the mounts
+	if spec, err := wasm.ReadSpec(pwd); err != nil {
+		logrus.WithError(err).Warn("failed to read spec to cleanup cgroup")
+	} else if spec.Linux != nil {
+		if err := wasm.DeleteCgroup(spec.Linux.CgroupsPath); err != nil {
+			logrus.WithError(err).Warn("failed to cleanup cgroup")
+		}
+	}
+
+	// Unmount rootfs mounts
 	if err := mount.UnmountAll(filepath.Join(pwd, "rootfs"), 0); err != nil {
 		logrus.WithError(err).Warn("failed to cleanup rootfs mount")
 	}
+	if err := wasm.UnmountPreopens(pwd); err != nil {
+		logrus.WithError(err).Warn("failed to cleanup preopen mounts")
+	}
 
 	// TODO: is returning the pid necessary
 	bytes, err := ioutil.ReadFile(filepath.Join(pwd, wasm.InitPidFile))
@@ -244,11 +270,30 @@ func (s *service) Cleanup(ctx context.Context) (*taskAPI.DeleteResponse, error)
 func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, err error) {
 	s.log.Info("wasm Create")
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	if _, ok := s.containers[r.ID]; ok {
+		s.mu.Unlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "container %s already exists", r.ID)
+	}
+	if _, ok := s.creating[r.ID]; ok {
+		s.mu.Unlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrAlreadyExists, "container %s is being created", r.ID)
+	}
+	if max := s.config.MaxInstances; max > 0 && s.instances()+len(s.creating) >= max {
+		s.mu.Unlock()
+		return nil, errdefs.ToGRPCf(errdefs.ErrUnavailable, "shim already runs %d wasm instances", max)
+	}
+	// compiling the module may take a while, other requests are served
+	// meanwhile with the id reserved
+	s.creating[r.ID] = struct{}{}
+	s.mu.Unlock()
+
+	container, err := wasm.NewContainer(ctx, s.platform, s.config, r, s.ec)
 
-	container, err := wasm.NewContainer(ctx, s.platform, r, s.ec)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.creating, r.ID)
 	if err != nil {
-		return nil, err
+		return nil, errdefs.ToGRPC(err)
 	}
 
 	s.containers[r.ID] = container
@@ -288,11 +333,11 @@ func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.
 		return nil, errdefs.ToGRPC(err)
 	}
 
-	//if err := s.ep.Add(container.ID, container.Cgroup()); err != nil {
-	//	logrus.WithError(err).Error("add cg to OOM monitor")
-	//}
 	switch r.ExecID {
 	case "":
+		if err := s.ep.Add(container); err != nil {
+			logrus.WithError(err).Error("add cg to OOM monitor")
+		}
 		s.send(&eventstypes.TaskStart{
 			ContainerID: container.ID,
 			Pid:         uint32(p.Pid()),
@@ -328,6 +373,7 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAP
 		delete(s.containers, r.ID)
 		hasContainers := len(s.containers) > 0
 		s.mu.Unlock()
+		s.ep.Remove(r.ID)
 		if s.platform != nil && !hasContainers {
 			s.platform.Close()
 		}
@@ -427,9 +473,7 @@ func (s *service) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.
 
 // Pause the container
 func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.Empty, error) {
-
 	s.log.Info("wasm Pause")
-	return nil, errdefs.ErrNotImplemented
 	container, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -446,7 +490,6 @@ func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.E
 // Resume the container
 func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
 	s.log.Info("wasm Resume")
-	return nil, errdefs.ErrNotImplemented
 	container, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -541,7 +584,6 @@ func (s *service) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskReque
 // Update a running container
 func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
 	s.log.Info("wasm Update")
-	return nil, errdefs.ErrNotImplemented
 	container, err := s.getContainer(r.ID)
 	if err != nil {
 		return nil, err
@@ -588,8 +630,8 @@ func (s *service) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (*task
 func (s *service) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (*ptypes.Empty, error) {
 	s.log.Info("wasm Shutdown")
 	s.mu.Lock()
-	// return out if the shim is still servicing containers
-	if len(s.containers) > 0 {
+	// return out if the shim is still servicing or creating containers
+	if len(s.containers) > 0 || len(s.creating) > 0 {
 		s.mu.Unlock()
 		return empty, nil
 	}
@@ -605,28 +647,11 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.
 	if err != nil {
 		return nil, err
 	}
-	cgx := container.Cgroup()
-	if cgx == nil {
-		return nil, errdefs.ToGRPCf(errdefs.ErrNotFound, "cgroup does not exist")
-	}
-	var statsx interface{}
-	switch cg := cgx.(type) {
-	case cgroups.Cgroup:
-		stats, err := cg.Stat(cgroups.IgnoreNotExist)
-		if err != nil {
-			return nil, err
-		}
-		statsx = stats
-	case *cgroupsv2.Manager:
-		stats, err := cg.Stat()
-		if err != nil {
-			return nil, err
-		}
-		statsx = stats
-	default:
-		return nil, errdefs.ToGRPCf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	stats, err := container.Stats(ctx)
+	if err != nil {
+		return nil, errdefs.ToGRPC(err)
 	}
-	data, err := typeurl.MarshalAny(statsx)
+	data, err := typeurl.MarshalAny(stats)
 	if err != nil {
 		return nil, err
 	}
@@ -657,6 +682,10 @@ func (s *service) checkProcesses(e wasm.Exit) {
 	defer s.mu.Unlock()
 
 	for _, container := range s.containers {
+		// in-process instances all report the shim's pid
+		if e.ID != "" && e.ID != container.ID {
+			continue
+		}
 		if container.HasPid(e.Pid) {
 			//shouldKillAll, err := shouldKillAllOnExit(container.Bundle)
 			//if err != nil {
@@ -675,6 +704,12 @@ func (s *service) checkProcesses(e wasm.Exit) {
 					//	}
 					//}
 					p.SetExited(e.Status)
+					// kubelet reports OOMKilled when the OOM precedes the exit
+					if e.Reason == wasm.ExitReasonMemoryExhausted {
+						s.sendL(&eventstypes.TaskOOM{
+							ContainerID: container.ID,
+						})
+					}
 					s.sendL(&eventstypes.TaskExit{
 						ContainerID: container.ID,
 						ID:          p.ID(),
@@ -745,6 +780,18 @@ func (s *service) forward(ctx context.Context, publisher shim.Publisher) {
 	publisher.Close()
 }
 
+// instances returns the number of wasm containers, excluding sandboxes.
+// The caller must hold s.mu.
+func (s *service) instances() int {
+	var n int
+	for _, c := range s.containers {
+		if !c.Sandbox() {
+			n++
+		}
+	}
+	return n
+}
+
 func (s *service) getContainer(id string) (*wasm.Container, error) {
 	s.mu.Lock()
 	container := s.containers[id]
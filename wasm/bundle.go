@@ -0,0 +1,198 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/cri/pkg/annotations"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReadSpec reads the OCI spec of a bundle
+func ReadSpec(bundle string) (*specs.Spec, error) {
+	b, err := ioutil.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read spec")
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(b, &spec); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal spec")
+	}
+	if spec.Process == nil {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "no process specification")
+	}
+	return &spec, nil
+}
+
+// SpecRootfs returns the root filesystem named by the spec of a bundle,
+// for bundles whose rootfs is not passed as mounts
+func SpecRootfs(bundle string, spec *specs.Spec) (string, error) {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return "", errors.Wrap(errdefs.ErrInvalidArgument, "no root path in spec")
+	}
+	if filepath.IsAbs(spec.Root.Path) {
+		return spec.Root.Path, nil
+	}
+	return filepath.Join(bundle, spec.Root.Path), nil
+}
+
+// ResolveSpec selects the engine for the OCI spec of a bundle whose root
+// filesystem is at rootfs and resolves the module invocation, as Create
+// does for non sandbox containers. The module is validated against the
+// engine but neither compiled nor prepared.
+func ResolveSpec(ctx context.Context, config *Config, id, bundle, rootfs string, spec *specs.Spec, opts *options.Options) (Engine, *Spec, error) {
+	engine, err := selectEngine(spec.Annotations, opts.Engine, config.DefaultEngine)
+	if err != nil {
+		return nil, nil, err
+	}
+	guest, module, err := FindModule(rootfs, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	mounts, err := mountDirs(config, id, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	maxPages, err := maxMemoryPages(config, spec, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	args := spec.Process.Args
+	if len(args) == 0 {
+		args = []string{guest}
+	}
+	s := &Spec{
+		ID:         id,
+		Bundle:     bundle,
+		Rootfs:     rootfs,
+		Module:     module,
+		ModulePath: guest,
+		Args:       args,
+		Env:        append([]string(nil), spec.Process.Env...),
+		Options:    opts,
+		Dirs: append([]Dir{
+			{Host: rootfs, Guest: "/", ReadOnly: spec.Root != nil && spec.Root.Readonly},
+		}, mounts...),
+		MaxMemoryPages: maxPages,
+	}
+	if cwd := spec.Process.Cwd; cwd != "" {
+		if s.Cwd, err = resolveDir(s, cwd); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to resolve working directory")
+		}
+		// WASI has no working directory, guests track their own and
+		// toolchains such as Go's initialize it from $PWD
+		if !hasEnv(s.Env, "PWD") {
+			s.Env = append(s.Env, "PWD="+s.Cwd)
+		}
+	}
+	if s.Capabilities, err = ProbeEngine(ctx, engine, s); err != nil {
+		return nil, nil, err
+	}
+	if err := validateModule(s); err != nil {
+		return nil, nil, err
+	}
+	if err := validateDirs(s); err != nil {
+		return nil, nil, err
+	}
+	if err := validateMemory(s); err != nil {
+		return nil, nil, err
+	}
+	return engine, s, nil
+}
+
+// CreateSpec resolves and prepares the invocation of a container's
+// process, as Create does. Sandbox containers are run as native processes
+// by the sandbox engine, other containers have their module compiled when
+// their engine supports it.
+func CreateSpec(ctx context.Context, config *Config, id, bundle, rootfs string, spec *specs.Spec, opts *options.Options) (Engine, *Spec, error) {
+	var (
+		engine Engine
+		s      *Spec
+		err    error
+	)
+	if isSandbox(spec) {
+		engine = sandboxEngine{}
+		s = &Spec{
+			ID:      id,
+			Bundle:  bundle,
+			Rootfs:  rootfs,
+			Args:    spec.Process.Args,
+			Env:     spec.Process.Env,
+			Options: opts,
+		}
+		if len(s.Args) > 0 {
+			if s.Module, err = resolveFile(rootfs, s.Args[0]); err != nil {
+				return nil, nil, errors.Wrap(err, "failed to resolve entrypoint")
+			}
+		}
+		if s.Capabilities, err = ProbeEngine(ctx, engine, s); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if engine, s, err = ResolveSpec(ctx, config, id, bundle, rootfs, spec, opts); err != nil {
+			return nil, nil, err
+		}
+		if err := createCwd(s); err != nil {
+			return nil, nil, err
+		}
+		c, err := OpenCache(config, opts)
+		if err == nil {
+			err = Precompile(ctx, c, engine, s)
+		}
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to precompile module %s, running it as is", s.ModulePath)
+		}
+	}
+	if err := engine.Prepare(ctx, s); err != nil {
+		return nil, nil, err
+	}
+	return engine, s, nil
+}
+
+// crioContainerType is the CRI-O counterpart of annotations.ContainerType
+const crioContainerType = "io.kubernetes.cri-o.ContainerType"
+
+// isSandbox checks whether a container is a sandbox container, run as a
+// native process. Only containers marked as such by CRI or CRI-O are, all
+// others must run a module.
+func isSandbox(spec *specs.Spec) bool {
+	for _, key := range []string{annotations.ContainerType, crioContainerType} {
+		if t, ok := spec.Annotations[key]; ok {
+			return t == annotations.ContainerTypeSandbox
+		}
+	}
+	return false
+}
+
+// hasEnv checks whether an environment in KEY=value form sets key
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package cache stores natively compiled modules on disk, addressed by the
+// digest of the module and the engine that compiled them. A cache directory
+// may be shared by any number of processes: entries are created under an
+// exclusive file lock and published with an atomic rename. Each artifact is
+// verified against its recorded digest before it is reused and the least
+// recently used artifacts are evicted to keep the cache within its budget.
+package cache
+
+import (
+	"context"
+	_ "crypto/sha256" // registers the digest algorithm
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Key of a compiled module
+type Key struct {
+	// Module is the digest of the wasm module
+	Module digest.Digest
+	// Engine that compiled the module
+	Engine string
+	// Version of the engine, artifacts are not portable across versions
+	Version string
+	// Config identifies the engine binary and arguments the module was
+	// compiled with, which artifacts depend on as well
+	Config string
+}
+
+func (k Key) validate() error {
+	if err := k.Module.Validate(); err != nil {
+		return errors.Wrap(err, "invalid module digest")
+	}
+	for _, s := range []string{k.Engine, k.Version, k.Config} {
+		if s == "" || s == "." || s == ".." || filepath.Base(s) != s {
+			return errors.Errorf("invalid cache key component %q", s)
+		}
+	}
+	return nil
+}
+
+// CompileFunc writes the artifact for a module to path
+type CompileFunc func(ctx context.Context, path string) error
+
+// Entry of the cache
+type Entry struct {
+	Key
+	// Path of the artifact
+	Path string
+	// Size of the artifact in bytes
+	Size int64
+	// Digest of the artifact as written by the compiler
+	Digest digest.Digest
+	// Accessed is when the artifact was last handed out
+	Accessed time.Time
+}
+
+// meta is stored next to each artifact as <artifact>.json
+type meta struct {
+	Size     int64         `json:"size"`
+	Digest   digest.Digest `json:"digest"`
+	Accessed time.Time     `json:"accessed"`
+}
+
+// minEvictAge protects artifacts handed out recently, which containers
+// created but not yet started are about to run
+const minEvictAge = 10 * time.Minute
+
+// Cache of compiled modules rooted at a directory
+type Cache struct {
+	root   string
+	budget int64
+}
+
+// New returns the cache rooted at dir, creating it if needed. When budget
+// is positive, least recently used artifacts are evicted once the cache
+// grows over budget bytes.
+func New(dir string, budget int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create cache directory")
+	}
+	return &Cache{root: dir, budget: budget}, nil
+}
+
+// Root directory of the cache
+func (c *Cache) Root() string {
+	return c.root
+}
+
+// path of the artifact for key: <root>/<engine>/<version>/<config>/<algorithm>/<encoded>
+func (c *Cache) path(key Key) string {
+	return filepath.Join(c.root, key.Engine, key.Version, key.Config, key.Module.Algorithm().String(), key.Module.Encoded())
+}
+
+// Get returns the path of the artifact for key. On a miss the artifact is
+// compiled into a temporary file and renamed into place. Processes asking
+// for the same key wait for the first one to finish compiling. Artifacts
+// failing verification are discarded and compiled again.
+func (c *Cache) Get(ctx context.Context, key Key, compile CompileFunc) (string, error) {
+	if err := key.validate(); err != nil {
+		return "", err
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	unlock, err := lock(path, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	m, err := verify(path)
+	switch {
+	case err == nil:
+		m.Accessed = time.Now()
+		if err := writeMeta(path, m); err != nil {
+			return "", err
+		}
+		return path, nil
+	case errors.Cause(err) == errCorrupt:
+		logrus.WithError(err).Warnf("discarding cached artifact %s", path)
+		if err := remove(path); err != nil {
+			return "", err
+		}
+	case !os.IsNotExist(errors.Cause(err)):
+		return "", err
+	}
+
+	if err := c.compile(ctx, path, compile); err != nil {
+		return "", errors.Wrapf(err, "failed to compile %s", key.Module)
+	}
+	if c.budget > 0 {
+		if _, err := c.prune(c.budget, path); err != nil {
+			logrus.WithError(err).Warn("failed to prune the compile cache")
+		}
+	}
+	return path, nil
+}
+
+// compile the artifact at path, publishing it and its metadata atomically
+func (c *Cache) compile(ctx context.Context, path string, compile CompileFunc) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".compile-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+	if err := compile(ctx, tmp); err != nil {
+		return err
+	}
+	size, dgst, err := digestFile(tmp)
+	if err != nil {
+		return err
+	}
+	if err := writeMeta(path, &meta{Size: size, Digest: dgst, Accessed: time.Now()}); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// List the entries of the cache
+func (c *Cache) List() ([]Entry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.root, "*", "*", "*", "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, p := range paths {
+		path := strings.TrimSuffix(p, ".json")
+		rel, err := filepath.Rel(c.root, path)
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		key := Key{
+			Engine:  parts[0],
+			Version: parts[1],
+			Config:  parts[2],
+			Module:  digest.NewDigestFromEncoded(digest.Algorithm(parts[3]), parts[4]),
+		}
+		if key.validate() != nil {
+			continue
+		}
+		m, err := readMeta(path)
+		if err != nil {
+			// entries are removed artifact first, the metadata may be stale
+			if os.IsNotExist(errors.Cause(err)) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Key:      key,
+			Path:     path,
+			Size:     m.Size,
+			Digest:   m.Digest,
+			Accessed: m.Accessed,
+		})
+	}
+	return entries, nil
+}
+
+// Prune evicts least recently used entries until the cache is no larger
+// than max bytes, returning the evicted entries. Entries in use by another
+// process or handed out in the last minutes are kept.
+func (c *Cache) Prune(max int64) ([]Entry, error) {
+	return c.prune(max, "")
+}
+
+func (c *Cache) prune(max int64, keep string) ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Accessed.Before(entries[j].Accessed)
+	})
+	var evicted []Entry
+	for _, e := range entries {
+		if total <= max {
+			break
+		}
+		if e.Path == keep || time.Since(e.Accessed) < minEvictAge {
+			continue
+		}
+		unlock, err := lock(e.Path, false)
+		if err != nil {
+			continue
+		}
+		err = remove(e.Path)
+		unlock()
+		if err != nil {
+			return evicted, err
+		}
+		total -= e.Size
+		evicted = append(evicted, e)
+	}
+	return evicted, nil
+}
+
+// Verify checks the digest of every entry, removing and returning those
+// that are corrupt
+func (c *Cache) Verify() ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	var corrupt []Entry
+	for _, e := range entries {
+		unlock, err := lock(e.Path, true)
+		if err != nil {
+			return corrupt, err
+		}
+		_, err = verify(e.Path)
+		if errors.Cause(err) == errCorrupt {
+			err = remove(e.Path)
+			corrupt = append(corrupt, e)
+		} else if os.IsNotExist(errors.Cause(err)) {
+			err = nil
+		}
+		unlock()
+		if err != nil {
+			return corrupt, err
+		}
+	}
+	return corrupt, nil
+}
+
+var errCorrupt = errors.New("corrupt artifact")
+
+// verify the artifact at path against its metadata
+func verify(path string) (*meta, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	m, err := readMeta(path)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, errors.Wrap(errCorrupt, "no metadata")
+		}
+		return nil, err
+	}
+	size, dgst, err := digestFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if size != m.Size || dgst != m.Digest {
+		return nil, errors.Wrapf(errCorrupt, "got %d bytes with digest %s, expected %d bytes with digest %s", size, dgst, m.Size, m.Digest)
+	}
+	return m, nil
+}
+
+func readMeta(path string) (*meta, error) {
+	b, err := ioutil.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var m meta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, errors.Wrapf(errCorrupt, "invalid metadata: %v", err)
+	}
+	return &m, nil
+}
+
+// writeMeta replaces the metadata of the artifact at path
+func writeMeta(path string, m *meta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".meta-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path+".json")
+}
+
+// remove the artifact at path and then its metadata. The lock file stays,
+// removing it would let two processes lock the same entry.
+func remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + ".json"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// lock takes an exclusive lock for the entry at path, without waiting for
+// it unless wait is set
+func lock(path string, wait bool) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	how := unix.LOCK_EX
+	if !wait {
+		how |= unix.LOCK_NB
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock %s", path)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// DigestFile returns the sha256 digest of the file at path
+func DigestFile(path string) (digest.Digest, error) {
+	_, dgst, err := digestFile(path)
+	return dgst, err
+}
+
+func digestFile(path string) (int64, digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, digester.Digest(), nil
+}
@@ -0,0 +1,307 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// unifiedMountpoint is where the cgroup v2 hierarchy is mounted
+const unifiedMountpoint = "/sys/fs/cgroup"
+
+// cgroupExecArg0 is the argv[0] the shim re-executes itself with to start
+// engine commands paused until they are placed in the container's cgroup
+const cgroupExecArg0 = "containerd-shim-wasm-cgroup-exec"
+
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == cgroupExecArg0 {
+		cgroupExec()
+	}
+}
+
+// cgroupExec waits for the shim to place the process in the container's
+// cgroup, signalled on fd 3, then executes the engine command. The command
+// never runs outside of the cgroup.
+func cgroupExec() {
+	ready := os.NewFile(3, "ready")
+	b := make([]byte, 1)
+	if n, _ := ready.Read(b); n == 0 {
+		// the shim failed to place the process and gave up on it
+		os.Exit(1)
+	}
+	ready.Close()
+	err := unix.Exec(os.Args[1], os.Args[2:], os.Environ())
+	fmt.Fprintf(os.Stderr, "failed to execute %s: %v\n", os.Args[1], err)
+	os.Exit(127)
+}
+
+// NewCgroup creates the cgroup named by spec.Linux.CgroupsPath with the
+// resources of the spec applied, it returns nil when the spec names none.
+// The cgroup is either cgroups.Cgroup or *cgroupsv2.Manager.
+func NewCgroup(spec *specs.Spec) (interface{}, error) {
+	if spec.Linux == nil || spec.Linux.CgroupsPath == "" {
+		return nil, nil
+	}
+	path, err := cgroupPath(spec.Linux.CgroupsPath)
+	if err != nil {
+		return nil, err
+	}
+	resources := spec.Linux.Resources
+	if resources == nil {
+		resources = &specs.LinuxResources{}
+	}
+	var cg interface{}
+	if cgroups.Mode() == cgroups.Unified {
+		cg, err = cgroupsv2.NewManager(unifiedMountpoint, path, cgroupsv2.ToResources(resources))
+	} else {
+		cg, err = cgroups.New(cgroups.V1, cgroups.StaticPath(path), resources)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create cgroup %s", path)
+	}
+	return cg, nil
+}
+
+// cgroupKillTimeout is how long DeleteCgroup waits for the processes it
+// killed to leave the cgroup
+const cgroupKillTimeout = 10 * time.Second
+
+// DeleteCgroup removes the cgroup created by NewCgroup for a spec's cgroups
+// path, killing the processes left in it
+func DeleteCgroup(cgroupsPath string) error {
+	if cgroupsPath == "" {
+		return nil
+	}
+	path, err := cgroupPath(cgroupsPath)
+	if err != nil {
+		return err
+	}
+	if cgroups.Mode() == cgroups.Unified {
+		cg, err := cgroupsv2.LoadManager(unifiedMountpoint, path)
+		if err != nil {
+			return err
+		}
+		if err := killCgroup(cg); err != nil {
+			return err
+		}
+		return deleteCgroup(cg)
+	}
+	cg, err := cgroups.Load(cgroups.V1, cgroups.StaticPath(path))
+	if err != nil {
+		if err == cgroups.ErrCgroupDeleted {
+			return nil
+		}
+		return err
+	}
+	if err := killCgroup(cg); err != nil {
+		return err
+	}
+	return deleteCgroup(cg)
+}
+
+// killCgroup kills the processes of a cgroup and waits for them to leave it
+func killCgroup(cg interface{}) error {
+	deadline := time.Now().Add(cgroupKillTimeout)
+	for {
+		pids, err := cgroupProcs(cg)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("%d processes left in the cgroup after SIGKILL", len(pids))
+		}
+		for _, pid := range pids {
+			if err := unix.Kill(pid, unix.SIGKILL); err != nil && err != unix.ESRCH {
+				return errors.Wrapf(err, "failed to kill process %d", pid)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cgroupProcs returns the processes of a cgroup and its descendants
+func cgroupProcs(cg interface{}) ([]int, error) {
+	var pids []int
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		subsystems := cg.Subsystems()
+		if len(subsystems) == 0 {
+			return nil, nil
+		}
+		procs, err := cg.Processes(subsystems[0].Name(), true)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, p := range procs {
+			pids = append(pids, p.Pid)
+		}
+	case *cgroupsv2.Manager:
+		procs, err := cg.Procs(true)
+		if err != nil {
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, pid := range procs {
+			pids = append(pids, int(pid))
+		}
+	default:
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+	return pids, nil
+}
+
+func deleteCgroup(cg interface{}) error {
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		return cg.Delete()
+	case *cgroupsv2.Manager:
+		if err := cg.Delete(); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	default:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+}
+
+// updateCgroup applies resources to the cgroup NewCgroup created for a
+// spec's cgroups path
+func updateCgroup(cg interface{}, cgroupsPath string, resources *specs.LinuxResources) error {
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		return cg.Update(resources)
+	case *cgroupsv2.Manager:
+		// the v2 manager has no update, creating the existing cgroup
+		// again writes the resources
+		path, err := cgroupPath(cgroupsPath)
+		if err != nil {
+			return err
+		}
+		_, err = cgroupsv2.NewManager(unifiedMountpoint, path, cgroupsv2.ToResources(resources))
+		return err
+	default:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+}
+
+// freezeCgroup freezes or thaws the processes of a cgroup, waiting for
+// the freezer to reach the state
+func freezeCgroup(cg interface{}, freeze bool) error {
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		if freeze {
+			return cg.Freeze()
+		}
+		return cg.Thaw()
+	case *cgroupsv2.Manager:
+		if freeze {
+			return cg.Freeze()
+		}
+		return cg.Thaw()
+	default:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+}
+
+// AddToCgroup places a process in a cgroup returned by NewCgroup
+func AddToCgroup(cg interface{}, pid int) error {
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		return cg.Add(cgroups.Process{Pid: pid})
+	case *cgroupsv2.Manager:
+		return cg.AddProc(uint64(pid))
+	default:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+}
+
+// runsProcess checks whether an engine runs modules in a process of their
+// own, which can be placed in the container's cgroup
+func runsProcess(e Engine) bool {
+	if _, ok := e.(sandboxEngine); ok {
+		return true
+	}
+	_, ok := e.(commandEngine)
+	return ok
+}
+
+// cgroupPath translates the cgroups path of a spec into a cgroup path.
+// Systemd paths, in slice:prefix:name form, name a scope within the slice's
+// hierarchy, which is created directly rather than through systemd.
+func cgroupPath(path string) (string, error) {
+	parts := strings.Split(path, ":")
+	if len(parts) != 3 {
+		return filepath.Join("/", path), nil
+	}
+	slice, prefix, name := parts[0], parts[1], parts[2]
+	if slice == "" {
+		slice = "system.slice"
+	}
+	dir, err := expandSlice(slice)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(name, ".slice") {
+		if prefix != "" {
+			name = prefix + "-" + name
+		}
+		name += ".scope"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// expandSlice returns the path of a systemd slice, nested in the slices
+// named by its dash separated prefixes
+func expandSlice(slice string) (string, error) {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == slice || strings.Contains(name, "/") {
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "invalid systemd slice %q", slice)
+	}
+	if name == "-" {
+		return "/", nil
+	}
+	var path, prefix string
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", errors.Wrapf(errdefs.ErrInvalidArgument, "invalid systemd slice %q", slice)
+		}
+		path += "/" + prefix + component + ".slice"
+		prefix += component + "-"
+	}
+	return path, nil
+}
@@ -0,0 +1,102 @@
+// +build linux
+
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+func TestCgroupPath(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		expected string
+		invalid  bool
+	}{
+		{path: "/kubepods/besteffort/pod1234/abcd", expected: "/kubepods/besteffort/pod1234/abcd"},
+		{path: "wasm/abcd", expected: "/wasm/abcd"},
+		{
+			path:     "kubepods-besteffort-pod1234.slice:cri-containerd:abcd",
+			expected: "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1234.slice/cri-containerd-abcd.scope",
+		},
+		{path: "system.slice:docker:abcd", expected: "/system.slice/docker-abcd.scope"},
+		{path: ":cri-containerd:abcd", expected: "/system.slice/cri-containerd-abcd.scope"},
+		{path: "user.slice::abcd", expected: "/user.slice/abcd.scope"},
+		{path: "-.slice:wasm:abcd", expected: "/wasm-abcd.scope"},
+		{path: "kubepods.slice:cri-containerd:kubepods-burstable.slice", expected: "/kubepods.slice/kubepods-burstable.slice"},
+		{path: "kubepods:cri-containerd:abcd", invalid: true},
+		{path: "kubepods--besteffort.slice:cri-containerd:abcd", invalid: true},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			path, err := cgroupPath(tc.path)
+			if tc.invalid {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("expected invalid argument, got %q, %v", path, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, path)
+			}
+		})
+	}
+}
+
+func TestExpandSlice(t *testing.T) {
+	for _, tc := range []struct {
+		slice    string
+		expected string
+		invalid  bool
+	}{
+		{slice: "-.slice", expected: "/"},
+		{slice: "system.slice", expected: "/system.slice"},
+		{slice: "kubepods-besteffort.slice", expected: "/kubepods.slice/kubepods-besteffort.slice"},
+		{
+			slice:    "kubepods-besteffort-pod1234_5678.slice",
+			expected: "/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod1234_5678.slice",
+		},
+		{slice: "", invalid: true},
+		{slice: ".slice", invalid: true},
+		{slice: "system", invalid: true},
+		{slice: "a/b.slice", invalid: true},
+		{slice: "-a.slice", invalid: true},
+		{slice: "a-.slice", invalid: true},
+		{slice: "a--b.slice", invalid: true},
+	} {
+		t.Run(tc.slice, func(t *testing.T) {
+			path, err := expandSlice(tc.slice)
+			if tc.invalid {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("expected invalid argument, got %q, %v", path, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != tc.expected {
+				t.Fatalf("expected %s, got %s", tc.expected, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+// commandEngine is implemented by engines running modules through a command
+type commandEngine interface {
+	// command returns the command line the spec is run with
+	command(s *Spec) []string
+}
+
+// CommandLine returns the command line the engine runs the spec with, nil
+// for engines running modules inside the shim
+func CommandLine(e Engine, s *Spec) []string {
+	if c, ok := e.(commandEngine); ok {
+		return c.command(s)
+	}
+	return nil
+}
+
+// startCommand starts cmd with the provided stdio and returns it as an
+// Instance. With a cgroup in the spec, the command is started paused and
+// only executed once placed in the cgroup.
+func startCommand(cmd *exec.Cmd, s *Spec, stdio IO) (Instance, error) {
+	cmd.Stdin = stdio.Stdin
+	cmd.Stdout = stdio.Stdout
+	cmd.Stderr = stdio.Stderr
+	// the engine dies with the process running it, so killing the shim or
+	// the init process of runwasm never leaves a module running
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	if s.Cgroup == nil {
+		if err := cmd.Start(); err != nil {
+			return nil, errors.Wrapf(err, "failed to start %s", cmd.Path)
+		}
+		return &commandInstance{cmd: cmd}, nil
+	}
+	if cmd.Err != nil {
+		return nil, errors.Wrapf(cmd.Err, "failed to start %s", cmd.Path)
+	}
+	path := cmd.Path
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	cmd.Args = append([]string{cgroupExecArg0, path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+	cmd.ExtraFiles = []*os.File{r}
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start %s", path)
+	}
+	if err := AddToCgroup(s.Cgroup, cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errors.Wrapf(err, "failed to add %s to cgroup", path)
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, errors.Wrapf(err, "failed to start %s", path)
+	}
+	return &commandInstance{cmd: cmd}, nil
+}
+
+// startModuleCommand starts an engine command running a module. When the
+// engine limits the module's memory, its stderr is scanned for the engine's
+// own memory limit errors.
+func startModuleCommand(cmd *exec.Cmd, s *Spec, stdio IO, memoryErrors []string) (Instance, error) {
+	if len(memoryErrors) == 0 || s.MaxMemoryPages == 0 || !s.Capabilities.Has(FeatureMemoryLimit) {
+		return startCommand(cmd, s, stdio)
+	}
+	stderr := &memoryErrorWriter{w: stdio.Stderr, patterns: memoryErrors}
+	stdio.Stderr = stderr
+	i, err := startCommand(cmd, s, stdio)
+	if err != nil {
+		return nil, err
+	}
+	i.(*commandInstance).stderr = stderr
+	return i, nil
+}
+
+// commandInstance is an Instance running in a child process of the shim
+type commandInstance struct {
+	cmd    *exec.Cmd
+	stderr *memoryErrorWriter
+}
+
+func (i *commandInstance) Pid() int {
+	return i.cmd.Process.Pid
+}
+
+func (i *commandInstance) Signal(sig syscall.Signal) error {
+	return i.cmd.Process.Signal(sig)
+}
+
+func (i *commandInstance) Wait() (int, error) {
+	err := i.cmd.Wait()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return -1, err
+		}
+	}
+	return exitStatus(i.cmd.ProcessState.Sys().(syscall.WaitStatus)), nil
+}
+
+func (i *commandInstance) Stats(ctx context.Context) (interface{}, error) {
+	return nil, errdefs.ErrNotImplemented
+}
+
+func (i *commandInstance) ExitReason() string {
+	if i.stderr != nil && i.cmd.ProcessState.ExitCode() != 0 && i.stderr.matched() {
+		return ExitReasonMemoryExhausted
+	}
+	return ""
+}
+
+// memoryErrorTail is the output kept to match patterns across writes
+const memoryErrorTail = 256
+
+// memoryErrorWriter forwards writes to w, looking for patterns
+type memoryErrorWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	patterns []string
+	tail     []byte
+	found    bool
+}
+
+func (m *memoryErrorWriter) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	if !m.found {
+		m.tail = append(m.tail, b...)
+		for _, pattern := range m.patterns {
+			if bytes.Contains(m.tail, []byte(pattern)) {
+				m.found = true
+				break
+			}
+		}
+		if len(m.tail) > memoryErrorTail {
+			m.tail = append(m.tail[:0], m.tail[len(m.tail)-memoryErrorTail:]...)
+		}
+	}
+	m.mu.Unlock()
+	if m.w == nil {
+		return len(b), nil
+	}
+	return m.w.Write(b)
+}
+
+func (m *memoryErrorWriter) matched() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.found
+}
+
+// exitStatus converts a wait status into the status reported to containerd
+func exitStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// sandboxEngine runs the sandbox (pause) container as a regular process
+type sandboxEngine struct{}
+
+func (sandboxEngine) Name() string {
+	return "sandbox"
+}
+
+func (sandboxEngine) Prepare(ctx context.Context, s *Spec) error {
+	if len(s.Args) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no sandbox command")
+	}
+	return nil
+}
+
+func (sandboxEngine) Start(ctx context.Context, s *Spec, stdio IO) (Instance, error) {
+	cmd := exec.Command(s.Module)
+	cmd.Args = s.Args
+	return startCommand(cmd, s, stdio)
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/dmcgowan/containerd-wasm/wasm/cache"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/pkg/errors"
+)
+
+// Compiler is implemented by engines that can compile modules ahead of
+// time. Compiled artifacts are cached on the node and handed to Start
+// through Spec.Artifact.
+type Compiler interface {
+	// Compile the module of the spec into a native artifact at path
+	Compile(ctx context.Context, s *Spec, path string) error
+}
+
+// OpenCache opens the compile cache named by the options, falling back to
+// the node wide cache directory
+func OpenCache(config *Config, opts *options.Options) (*cache.Cache, error) {
+	dir := config.CacheDir
+	if opts != nil && opts.CacheDir != "" {
+		dir = opts.CacheDir
+	}
+	return cache.New(dir, config.CacheMaxSize)
+}
+
+// Precompile sets Spec.Artifact to the compiled module from the cache,
+// compiling the module on a miss. Engines that cannot compile modules are
+// left to run them as is.
+func Precompile(ctx context.Context, c *cache.Cache, e Engine, s *Spec) error {
+	compiler, ok := e.(Compiler)
+	if !ok {
+		return nil
+	}
+	dgst, err := cache.DigestFile(s.Module)
+	if err != nil {
+		return errors.Wrap(err, "failed to digest module")
+	}
+	key := cache.Key{
+		Module:  dgst,
+		Engine:  e.Name(),
+		Version: s.Capabilities.Version,
+		Config:  compileConfig(s),
+	}
+	artifact, err := c.Get(ctx, key, func(ctx context.Context, path string) error {
+		return compiler.Compile(ctx, s, path)
+	})
+	if err != nil {
+		return err
+	}
+	s.Artifact = artifact
+	return nil
+}
+
+// compileConfig digests the engine binary and arguments of a spec, so
+// modules compiled with other settings are not reused for it
+func compileConfig(s *Spec) string {
+	h := sha256.New()
+	for _, v := range append([]string{s.Capabilities.Binary}, s.engineArgs()...) {
+		io.WriteString(h, v)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// runCompile runs an engine's compile command
+func runCompile(ctx context.Context, binary string, args ...string) error {
+	out, err := exec.CommandContext(ctx, binary, args...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s %s: %s", binary, strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultConfigPath is where the shim looks for its configuration
+	DefaultConfigPath = "/etc/containerd/containerd-shim-wasm.toml"
+	// ConfigEnv names an alternative configuration path
+	ConfigEnv = "CONTAINERD_SHIM_WASM_CONFIG"
+	// DefaultCacheDir holds compiled modules when no cache dir is configured
+	DefaultCacheDir = "/var/lib/containerd-shim-wasm/cache"
+	// DefaultEngineMemoryOverhead is left to the engine of containers with
+	// a memory limit when no overhead is configured
+	DefaultEngineMemoryOverhead = 32 << 20
+)
+
+// Config of the shim, shared by all of its containers
+type Config struct {
+	// DefaultEngine is used when a container and its options select none
+	DefaultEngine string `toml:"default_engine"`
+	// CacheDir is the node wide directory compiled modules are cached in
+	CacheDir string `toml:"cache_dir"`
+	// CacheMaxSize is the byte budget of the cache, 0 means no limit
+	CacheMaxSize int64 `toml:"cache_max_size"`
+	// LogLevel of the shim
+	LogLevel string `toml:"log_level"`
+	// MaxInstances limits the modules a shim runs at once, 0 means no limit
+	MaxInstances int `toml:"max_instances"`
+	// AllowedHostPreopens limits the host directories, besides the rootfs,
+	// that may be preopened for a module. Empty allows any directory.
+	AllowedHostPreopens []string `toml:"allowed_host_preopens"`
+	// EngineMemoryOverhead is subtracted from a container's memory limit
+	// to derive the maximum linear memory of its module, 0 means
+	// DefaultEngineMemoryOverhead
+	EngineMemoryOverhead int64 `toml:"engine_memory_overhead"`
+}
+
+// LoadConfig loads the shim configuration from the path in ConfigEnv, or
+// DefaultConfigPath when unset. A missing default file yields the defaults.
+func LoadConfig() (*Config, error) {
+	path, required := os.Getenv(ConfigEnv), true
+	if path == "" {
+		path, required = DefaultConfigPath, false
+	}
+	config := &Config{}
+	md, err := toml.DecodeFile(path, config)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return config.withDefaults(), nil
+		}
+		return nil, errors.Wrapf(err, "failed to load config %s", path)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unknown keys in config %s: %v", path, undecoded)
+	}
+	config = config.withDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid config %s", path)
+	}
+	return config, nil
+}
+
+func (c *Config) withDefaults() *Config {
+	if c.DefaultEngine == "" {
+		c.DefaultEngine = DefaultEngine
+	}
+	if c.CacheDir == "" {
+		c.CacheDir = DefaultCacheDir
+	}
+	if c.EngineMemoryOverhead == 0 {
+		c.EngineMemoryOverhead = DefaultEngineMemoryOverhead
+	}
+	return c
+}
+
+// Validate the configuration
+func (c *Config) Validate() error {
+	if _, err := GetEngine(c.DefaultEngine); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "default_engine %q is not one of %v", c.DefaultEngine, Engines())
+	}
+	if !filepath.IsAbs(c.CacheDir) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "cache_dir %q must be absolute", c.CacheDir)
+	}
+	if c.CacheMaxSize < 0 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "cache_max_size %d must not be negative", c.CacheMaxSize)
+	}
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "log_level: %v", err)
+		}
+	}
+	if c.MaxInstances < 0 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "max_instances %d must not be negative", c.MaxInstances)
+	}
+	if c.EngineMemoryOverhead < 0 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "engine_memory_overhead %d must not be negative", c.EngineMemoryOverhead)
+	}
+	for _, dir := range c.AllowedHostPreopens {
+		if !filepath.IsAbs(dir) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "allowed_host_preopens entry %q must be absolute", dir)
+		}
+	}
+	return nil
+}
@@ -21,10 +21,10 @@ package wasm
 import (
 	"context"
 	"encoding/json"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"syscall"
 
 	"github.com/containerd/cgroups"
 	cgroupsv2 "github.com/containerd/cgroups/v2"
@@ -35,7 +35,7 @@ import (
 	"github.com/containerd/containerd/pkg/stdio"
 	"github.com/containerd/containerd/runtime/v2/shim"
 	"github.com/containerd/containerd/runtime/v2/task"
-	"github.com/containerd/cri/pkg/annotations"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -56,32 +56,32 @@ type Container struct {
 	Bundle string
 
 	// cgroup is either cgroups.Cgroup or *cgroupsv2.Manager
-	cgroup    interface{}
-	ec        chan<- Exit
-	process   proc.Process
-	processes map[string]proc.Process
+	cgroup interface{}
+	// cgroupsPath of the spec, set when the cgroup was created for the
+	// container rather than inherited from the shim
+	cgroupsPath string
+	engine      Engine
+	ec          chan<- Exit
+	process     proc.Process
+	processes   map[string]proc.Process
 }
 
 type Exit struct {
+	// ID of the container the exited process belongs to
+	ID     string
 	Pid    int
 	Status int
+	// Reason the process exited for when known, such as
+	// ExitReasonMemoryExhausted
+	Reason string
 }
 
 // NewContainer returns a new wasm container
-func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTaskRequest, ec chan<- Exit) (c *Container, err error) {
-	//var opts options.Options
-	//if r.Options != nil {
-	//	v, err := typeurl.UnmarshalAny(r.Options)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	// TODO: Use custom options type
-	//	opts = *v.(*options.Options)
-	//}
-
-	//if err := WriteRuntime(r.Bundle, opts.BinaryName); err != nil {
-	//	return nil, err
-	//}
+func NewContainer(ctx context.Context, platform stdio.Platform, config *Config, r *task.CreateTaskRequest, ec chan<- Exit) (c *Container, err error) {
+	opts, err := options.Unmarshal(r.Options)
+	if err != nil {
+		return nil, err
+	}
 
 	var mounts []proc.Mount
 	for _, m := range r.Rootfs {
@@ -103,6 +103,11 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 
 	defer func() {
 		if err != nil {
+			if err2 := UnmountPreopens(r.Bundle); err2 != nil {
+				logrus.WithError(err2).Warn("failed to cleanup preopen mounts")
+			}
+		}
+		if err != nil && len(mounts) > 0 {
 			if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
 				logrus.WithError(err2).Warn("failed to cleanup rootfs mount")
 			}
@@ -119,21 +124,30 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 		}
 	}
 
-	// Read args from OCI config
-	b, err := ioutil.ReadFile(filepath.Join(r.Bundle, "config.json"))
+	spec, err := ReadSpec(r.Bundle)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read spec")
+		return nil, err
 	}
-	var spec specs.Spec
-	if err := json.Unmarshal(b, &spec); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal spec")
+	// without rootfs mounts the root of the spec is used as is, as runc does
+	if len(mounts) == 0 && spec.Root != nil {
+		if rootfs, err = SpecRootfs(r.Bundle, spec); err != nil {
+			return nil, err
+		}
 	}
-	if spec.Process == nil {
-		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "no process specification")
+	engine, s, err := CreateSpec(ctx, config, r.ID, r.Bundle, rootfs, spec, opts)
+	if err != nil {
+		return nil, err
 	}
-	if len(spec.Process.Args) > 0 {
-		// TODO: bound this
-		spec.Process.Args[0] = filepath.Join(rootfs, spec.Process.Args[0])
+	// the cgroup is created before the container is reported created, as
+	// runc does, and engine processes are started in it
+	var cg interface{}
+	if runsProcess(engine) {
+		if cg, err = NewCgroup(spec); err != nil {
+			return nil, err
+		}
+		s.Cgroup = cg
+	} else if spec.Linux != nil && spec.Linux.CgroupsPath != "" {
+		logrus.Debugf("%s runs modules inside the shim, not creating cgroup %s", engine.Name(), spec.Linux.CgroupsPath)
 	}
 
 	p := &process{
@@ -144,21 +158,25 @@ func NewContainer(ctx context.Context, platform stdio.Platform, r *task.CreateTa
 			Stderr:   r.Stderr,
 			Terminal: r.Terminal,
 		},
-		exited:    make(chan struct{}),
-		ec:        ec,
-		rootfs:    rootfs,
-		env:       spec.Process.Env,
-		args:      spec.Process.Args,
-		isSandbox: isSandbox(&spec),
+		exited: make(chan struct{}),
+		ec:     ec,
+		engine: engine,
+		spec:   s,
 	}
 
 	container := &Container{
 		ID:        r.ID,
 		Bundle:    r.Bundle,
+		cgroup:    cg,
+		engine:    engine,
 		process:   p,
 		processes: make(map[string]proc.Process),
 	}
 
+	if cg != nil {
+		container.cgroupsPath = spec.Linux.CgroupsPath
+	}
+
 	logrus.Infof("process created: %#v", p)
 	return container, nil
 }
@@ -194,6 +212,12 @@ func (c *Container) Pid() int {
 	return c.process.Pid()
 }
 
+// Sandbox returns true if the container is the pod sandbox rather than a module
+func (c *Container) Sandbox() bool {
+	_, ok := c.engine.(sandboxEngine)
+	return ok
+}
+
 // Cgroup of the container
 func (c *Container) Cgroup() interface{} {
 	c.mu.Lock()
@@ -254,7 +278,7 @@ func (c *Container) Start(ctx context.Context, r *task.StartRequest) (proc.Proce
 	if err != nil {
 		return nil, err
 	}
-	logrus.Info("got process %#v", p)
+	logrus.Infof("got process %#v", p)
 	if err := p.Start(ctx); err != nil {
 		return nil, err
 	}
@@ -267,7 +291,8 @@ func (c *Container) Start(ctx context.Context, r *task.StartRequest) (proc.Proce
 	}
 
 	logrus.Info("done starting", p)
-	if c.Cgroup() == nil && p.Pid() > 0 {
+	// in-process instances share the shim's cgroup
+	if c.Cgroup() == nil && p.Pid() > 0 && p.Pid() != os.Getpid() {
 		var cg interface{}
 		if cgroups.Mode() == cgroups.Unified {
 			g, err := cgroupsv2.PidGroupPath(p.Pid())
@@ -293,6 +318,29 @@ func (c *Container) Start(ctx context.Context, r *task.StartRequest) (proc.Proce
 	return p, nil
 }
 
+// Stats returns the metrics reported by the container's engine, or the
+// metrics of its cgroup when the engine has none of its own
+func (c *Container) Stats(ctx context.Context) (interface{}, error) {
+	p, err := c.Process("")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := p.(*process).Stats(ctx)
+	if !errdefs.IsNotImplemented(err) {
+		return stats, err
+	}
+	switch cg := c.Cgroup().(type) {
+	case nil:
+		return nil, errors.Wrap(errdefs.ErrNotFound, "cgroup does not exist")
+	case cgroups.Cgroup:
+		return cg.Stat(cgroups.IgnoreNotExist)
+	case *cgroupsv2.Manager:
+		return cg.Stat()
+	default:
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
+	}
+}
+
 // Delete the container or a process by id
 func (c *Container) Delete(ctx context.Context, r *task.DeleteRequest) (proc.Process, error) {
 	p, err := c.Process(r.ExecID)
@@ -304,6 +352,15 @@ func (c *Container) Delete(ctx context.Context, r *task.DeleteRequest) (proc.Pro
 	}
 	if r.ExecID != "" {
 		c.ProcessRemove(r.ExecID)
+	} else {
+		if err := UnmountPreopens(c.Bundle); err != nil {
+			logrus.WithError(err).Warn("failed to cleanup preopen mounts")
+		}
+		if c.cgroupsPath != "" {
+			if err := deleteCgroup(c.Cgroup()); err != nil {
+				logrus.WithError(err).Warn("failed to delete cgroup")
+			}
+		}
 	}
 	return p, nil
 }
@@ -313,14 +370,48 @@ func (c *Container) Exec(ctx context.Context, r *task.ExecProcessRequest) (proc.
 	return nil, errdefs.ErrNotImplemented
 }
 
-// Pause the container
+// Pause the container by freezing its cgroup
 func (c *Container) Pause(ctx context.Context) error {
-	return errdefs.ErrNotImplemented
+	p, err := c.freezable(ctx)
+	if err != nil {
+		return err
+	}
+	p.setPauseState("pausing")
+	if err := freezeCgroup(c.Cgroup(), true); err != nil {
+		p.setPauseState("")
+		return errors.Wrap(err, "failed to freeze cgroup")
+	}
+	p.setPauseState("paused")
+	return nil
 }
 
-// Resume the container
+// Resume the container by thawing its cgroup
 func (c *Container) Resume(ctx context.Context) error {
-	return errdefs.ErrNotImplemented
+	p, err := c.freezable(ctx)
+	if err != nil {
+		return err
+	}
+	if err := freezeCgroup(c.Cgroup(), false); err != nil {
+		return errors.Wrap(err, "failed to thaw cgroup")
+	}
+	p.setPauseState("")
+	return nil
+}
+
+// freezable returns the init process of a container whose cgroup can be
+// frozen, only cgroups created for the container are
+func (c *Container) freezable(ctx context.Context) (*process, error) {
+	p, err := c.Process("")
+	if err != nil {
+		return nil, err
+	}
+	if c.cgroupsPath == "" {
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "container %s runs in the shim's cgroup which cannot be frozen", c.ID)
+	}
+	if status, _ := p.Status(ctx); status == "created" || status == "stopped" {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s is %s", c.ID, status)
+	}
+	return p.(*process), nil
 }
 
 // ResizePty of a process
@@ -342,7 +433,17 @@ func (c *Container) Kill(ctx context.Context, r *task.KillRequest) error {
 	if err != nil {
 		return err
 	}
-	return p.Kill(ctx, r.Signal, r.All)
+	if err := p.Kill(ctx, r.Signal, r.All); err != nil {
+		return err
+	}
+	// frozen processes only die once thawed
+	if status, _ := p.Status(ctx); status == "paused" && syscall.Signal(r.Signal) == syscall.SIGKILL {
+		if err := freezeCgroup(c.Cgroup(), false); err != nil {
+			return errors.Wrap(err, "failed to thaw cgroup")
+		}
+		p.(*process).setPauseState("")
+	}
+	return nil
 }
 
 // CloseIO of a process
@@ -364,9 +465,34 @@ func (c *Container) Checkpoint(ctx context.Context, r *task.CheckpointTaskReques
 	return errdefs.ErrNotImplemented
 }
 
-// Update the resource information of a running container
+// Update the resource information of a running container. The resources
+// are applied to the container's cgroup, then to the limits of its engine.
 func (c *Container) Update(ctx context.Context, r *task.UpdateTaskRequest) error {
-	return errdefs.ErrNotImplemented
+	if r.Resources == nil {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no resources to update")
+	}
+	var resources specs.LinuxResources
+	if err := json.Unmarshal(r.Resources.Value, &resources); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "failed to decode resources: %v", err)
+	}
+	p, err := c.Process("")
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	cg, cgroupsPath := c.cgroup, c.cgroupsPath
+	c.mu.Unlock()
+	if cgroupsPath == "" {
+		return errors.Wrapf(errdefs.ErrNotImplemented, "container %s has no cgroup of its own and wasm engine %s cannot update limits while running", c.ID, c.engine.Name())
+	}
+	if err := updateCgroup(cg, cgroupsPath, &resources); err != nil {
+		return errors.Wrap(err, "failed to update cgroup")
+	}
+	// engines fix the maximum linear memory when the module is started
+	if s := p.(*process).spec; resources.Memory != nil && resources.Memory.Limit != nil && s.MaxMemoryPages > 0 && s.Capabilities.Has(FeatureMemoryLimit) {
+		logrus.WithField("id", c.ID).Warnf("wasm engine %s keeps the maximum linear memory of %d pages until the container is restarted", c.engine.Name(), s.MaxMemoryPages)
+	}
+	return nil
 }
 
 // HasPid returns true if the container owns a specific pid
@@ -381,9 +507,3 @@ func (c *Container) HasPid(pid int) bool {
 	}
 	return false
 }
-
-// isSandbox checks whether a container is a sandbox container.
-func isSandbox(spec *specs.Spec) bool {
-	t, ok := spec.Annotations[annotations.ContainerType]
-	return !ok || t == annotations.ContainerTypeSandbox
-}
@@ -0,0 +1,147 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ModuleAnnotation names the module to run by its path in the image
+	ModuleAnnotation = "io.containerd.wasm.module"
+	// VariantAnnotation is set on images built for wasm runtimes, a
+	// compat-smart variant may also contain non wasm entrypoints
+	VariantAnnotation = "module.wasm.image/variant"
+)
+
+var (
+	wasmMagic     = []byte("\x00asm")
+	moduleVersion = []byte{0x01, 0x00, 0x00, 0x00}
+)
+
+// errNotModule is returned for files without a wasm module header
+var errNotModule = errors.Wrap(errdefs.ErrInvalidArgument, "not a wasm module")
+
+// FindModule returns the guest and host path of the module to run from
+// the module annotation, the entrypoint or the only .wasm file in the
+// rootfs, in that order. The rootfs is only searched when the spec has no
+// entrypoint, the entrypoint does not exist or, for images declaring a
+// wasm variant, is not a module. Only images whose rootfs holds a single
+// .wasm file are found this way, OCI wasm artifacts with a raw
+// application/vnd.wasm.content.layer.v1+wasm layer are not unpacked into a
+// rootfs and cannot be run.
+func FindModule(rootfs string, spec *specs.Spec) (string, string, error) {
+	if guest := spec.Annotations[ModuleAnnotation]; guest != "" {
+		host, err := resolveModule(rootfs, guest)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "module from %s annotation", ModuleAnnotation)
+		}
+		return guest, host, nil
+	}
+	if len(spec.Process.Args) > 0 {
+		guest := spec.Process.Args[0]
+		host, err := resolveFile(rootfs, guest)
+		if err == nil {
+			if err = checkModule(host); err == nil {
+				return guest, host, nil
+			}
+		}
+		hybrid := err == errNotModule && spec.Annotations[VariantAnnotation] != ""
+		if !hybrid && !errdefs.IsNotFound(err) {
+			return "", "", errors.Wrapf(err, "entrypoint %s", guest)
+		}
+	}
+	guest, err := searchModule(rootfs)
+	if err != nil {
+		return "", "", err
+	}
+	host, err := resolveModule(rootfs, guest)
+	if err != nil {
+		return "", "", err
+	}
+	return guest, host, nil
+}
+
+// resolveModule resolves a guest path to a wasm module inside rootfs
+func resolveModule(rootfs, guest string) (string, error) {
+	host, err := resolveFile(rootfs, guest)
+	if err != nil {
+		return "", err
+	}
+	if err := checkModule(host); err != nil {
+		return "", errors.Wrapf(err, "%s", guest)
+	}
+	return host, nil
+}
+
+// checkModule verifies that the file starts with a version 1 module header
+func checkModule(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header[:4], wasmMagic) {
+		return errNotModule
+	}
+	if !bytes.Equal(header[4:], moduleVersion) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "unsupported wasm binary version %x, only core modules are supported", header[4:])
+	}
+	return nil
+}
+
+// searchModule returns the guest path of the only .wasm file in rootfs
+func searchModule(rootfs string) (string, error) {
+	var found []string
+	errFound := errors.New("found")
+	err := filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), ".wasm") {
+			rel, err := filepath.Rel(rootfs, path)
+			if err != nil {
+				return err
+			}
+			found = append(found, "/"+rel)
+			if len(found) > 1 {
+				return errFound
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errFound {
+		return "", errors.Wrap(err, "failed to search rootfs for a module")
+	}
+	switch len(found) {
+	case 0:
+		return "", errors.Wrap(errdefs.ErrNotFound, "no wasm module found: no usable entrypoint, no "+ModuleAnnotation+" annotation and no .wasm file in the image")
+	case 1:
+		return found[0], nil
+	default:
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "no usable entrypoint and several .wasm files in the image (%s), set the %s annotation", strings.Join(found, ", "), ModuleAnnotation)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EngineAnnotation selects the engine used to run a container's module
+	EngineAnnotation = "io.containerd.wasm.engine"
+	// DefaultEngine is used when no engine is requested
+	DefaultEngine = "wasmer"
+)
+
+// Engine runs wasm modules on behalf of a container
+type Engine interface {
+	// Name of the engine as used in annotations and options
+	Name() string
+	// Prepare validates the spec before the container is reported created
+	Prepare(ctx context.Context, s *Spec) error
+	// Start the module described by the spec
+	Start(ctx context.Context, s *Spec, stdio IO) (Instance, error)
+}
+
+// Instance is a running module started by an Engine
+type Instance interface {
+	// Pid of the process executing the module
+	Pid() int
+	// Signal the instance
+	Signal(sig syscall.Signal) error
+	// Wait blocks until the instance exits and returns its exit status
+	Wait() (int, error)
+	// Stats returns a typeurl compatible metrics object for the instance.
+	// Instances running in their own process may return
+	// errdefs.ErrNotImplemented to have the container's cgroup used instead.
+	Stats(ctx context.Context) (interface{}, error)
+}
+
+// ExitReasonMemoryExhausted is the exit reason of modules trapped for
+// growing their linear memory past the maximum of the spec
+const ExitReasonMemoryExhausted = "WasmMemoryExhausted"
+
+// ExitReasoner is implemented by instances that can tell why they exited
+type ExitReasoner interface {
+	// ExitReason once Wait returned, empty when unknown
+	ExitReason() string
+}
+
+// IO streams for an Instance
+type IO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Spec describes the module invocation handed to an Engine
+type Spec struct {
+	// ID of the container
+	ID string
+	// Bundle path of the container
+	Bundle string
+	// Rootfs is the host directory exposed to the module as /
+	Rootfs string
+	// Module is the host path of the module the engine loads
+	Module string
+	// ModulePath is the guest path of the module
+	ModulePath string
+	// Artifact is the host path of the natively compiled module, set when
+	// the engine is a Compiler and the module was compiled
+	Artifact string
+	// Args to the module, Args[0] being the guest path of the module
+	Args []string
+	// Env of the module in KEY=value form
+	Env []string
+	// Cwd is the guest working directory, it must be inside one of Dirs
+	Cwd string
+	// Dirs are the host directories preopened for the module
+	Dirs []Dir
+	// MaxMemoryPages limits the linear memory of the module, 0 leaves it
+	// to the module
+	MaxMemoryPages uint32
+	// Options the container was created with
+	Options *options.Options
+	// Capabilities of the engine running the module
+	Capabilities *Capabilities
+	// Cgroup engine processes are placed in, either cgroups.Cgroup or
+	// *cgroupsv2.Manager. Nil leaves them in the shim's cgroup.
+	Cgroup interface{}
+}
+
+// Dir is a host directory preopened for a module
+type Dir struct {
+	// Host path of the directory
+	Host string
+	// Guest path the directory is exposed at
+	Guest string
+	// ReadOnly directories cannot be written to by the module
+	ReadOnly bool
+}
+
+// binary returns the configured engine binary, defaulting to name
+func (s *Spec) binary(name string) string {
+	if s.Options != nil && s.Options.EngineBinary != "" {
+		return s.Options.EngineBinary
+	}
+	return name
+}
+
+// file returns the host path of the artifact if any, else of the module
+func (s *Spec) file() string {
+	if s.Artifact != "" {
+		return s.Artifact
+	}
+	return s.Module
+}
+
+// engineArgs returns the configured extra engine arguments
+func (s *Spec) engineArgs() []string {
+	if s.Options == nil {
+		return nil
+	}
+	return s.Options.EngineArgs
+}
+
+// hostPath translates a guest path into a host path using the
+// preopened directory with the longest matching guest path
+func (s *Spec) hostPath(guest string) (string, bool) {
+	d, rel, ok := s.preopen(guest)
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(d.Host, rel), true
+}
+
+// preopen returns the preopened directory with the longest guest path
+// holding a guest path, and the path relative to it
+func (s *Spec) preopen(guest string) (Dir, string, bool) {
+	guest = filepath.Clean("/" + guest)
+	var (
+		dir  Dir
+		path string
+		best = -1
+	)
+	for _, d := range s.Dirs {
+		prefix := filepath.Clean("/" + d.Guest)
+		rel, err := filepath.Rel(prefix, guest)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") || len(prefix) <= best {
+			continue
+		}
+		dir, path, best = d, rel, len(prefix)
+	}
+	return dir, path, best >= 0
+}
+
+var (
+	enginesMu sync.Mutex
+	engines   = make(map[string]Engine)
+)
+
+// RegisterEngine makes an engine available to containers by name
+func RegisterEngine(e Engine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if _, ok := engines[e.Name()]; ok {
+		panic("wasm: engine registered twice: " + e.Name())
+	}
+	engines[e.Name()] = e
+}
+
+// GetEngine returns the registered engine by name
+func GetEngine(name string) (Engine, error) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	e, ok := engines[name]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "wasm engine %q", name)
+	}
+	return e, nil
+}
+
+// Engines returns the names of all registered engines
+func Engines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	var names []string
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectEngine returns the engine requested through the annotations,
+// falling back to the first named fallback and then the default engine
+func selectEngine(annotations map[string]string, fallbacks ...string) (Engine, error) {
+	name := annotations[EngineAnnotation]
+	for _, fallback := range fallbacks {
+		if name != "" {
+			break
+		}
+		name = fallback
+	}
+	if name == "" {
+		name = DefaultEngine
+	}
+	e, err := GetEngine(name)
+	if err != nil {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unknown wasm engine %q, available engines: %v", name, Engines())
+	}
+	return e, nil
+}
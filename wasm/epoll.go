@@ -20,12 +20,20 @@ package wasm
 
 import (
 	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"unsafe"
 
 	"github.com/containerd/cgroups"
+	cgroupsv2 "github.com/containerd/cgroups/v2"
 	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/runtime"
 	"github.com/containerd/containerd/runtime/v2/shim"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
@@ -44,7 +52,9 @@ func NewOOMEpoller(publisher shim.Publisher) (*Epoller, error) {
 	}, nil
 }
 
-// Epoller implementation for handling OOM events from a container's cgroup
+// Epoller implementation for handling OOM events from a container's cgroup.
+// Cgroup v1 memory controllers notify OOMs through an eventfd, for cgroup v2
+// the oom_kill counter of memory.events is watched with inotify.
 type Epoller struct {
 	mu sync.Mutex
 
@@ -55,7 +65,12 @@ type Epoller struct {
 
 type item struct {
 	id string
+	// cg is set for cgroup v1
 	cg cgroups.Cgroup
+	// events is the memory.events file of a cgroup v2 and oomKill its
+	// last oom_kill counter
+	events  string
+	oomKill uint64
 }
 
 // Close the epoll fd
@@ -86,18 +101,51 @@ func (e *Epoller) Run(ctx context.Context) {
 	}
 }
 
-// Add the cgroup to the epoll monitor
-func (e *Epoller) Add(id string, cg cgroups.Cgroup) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	fd, err := cg.OOMEventFD()
-	if err != nil {
-		return err
+// Add the cgroup of a container to the epoll monitor. Only cgroups created
+// for the container are monitored, containers in the shim's cgroup are
+// skipped.
+func (e *Epoller) Add(c *Container) error {
+	c.mu.Lock()
+	cg, cgroupsPath := c.cgroup, c.cgroupsPath
+	c.mu.Unlock()
+	if cgroupsPath == "" {
+		return nil
 	}
-	e.set[fd] = &item{
-		id: id,
-		cg: cg,
+	var (
+		fd uintptr
+		i  = &item{id: c.ID}
+	)
+	switch cg := cg.(type) {
+	case cgroups.Cgroup:
+		var err error
+		if fd, err = cg.OOMEventFD(); err != nil {
+			return err
+		}
+		i.cg = cg
+	case *cgroupsv2.Manager:
+		path, err := cgroupPath(cgroupsPath)
+		if err != nil {
+			return err
+		}
+		i.events = filepath.Join(unifiedMountpoint, path, "memory.events")
+		if i.oomKill, err = readOOMKill(i.events); err != nil {
+			return err
+		}
+		ifd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+		if err != nil {
+			return err
+		}
+		if _, err := unix.InotifyAddWatch(ifd, i.events, unix.IN_MODIFY); err != nil {
+			unix.Close(ifd)
+			return errors.Wrapf(err, "failed to watch %s", i.events)
+		}
+		fd = uintptr(ifd)
+	default:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "unsupported cgroup type %T", cg)
 	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.set[fd] = i
 	event := unix.EpollEvent{
 		Fd:     int32(fd),
 		Events: unix.EPOLLHUP | unix.EPOLLIN | unix.EPOLLERR,
@@ -105,8 +153,25 @@ func (e *Epoller) Add(id string, cg cgroups.Cgroup) error {
 	return unix.EpollCtl(e.fd, unix.EPOLL_CTL_ADD, int(fd), &event)
 }
 
+// Remove the cgroup of a container from the epoll monitor
+func (e *Epoller) Remove(id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for fd, i := range e.set {
+		if i.id == id {
+			e.remove(fd)
+		}
+	}
+}
+
+// remove a monitored fd, the lock must be held
+func (e *Epoller) remove(fd uintptr) {
+	unix.EpollCtl(e.fd, unix.EPOLL_CTL_DEL, int(fd), nil)
+	delete(e.set, fd)
+	unix.Close(int(fd))
+}
+
 func (e *Epoller) process(ctx context.Context, fd uintptr) {
-	flush(fd)
 	e.mu.Lock()
 	i, ok := e.set[fd]
 	if !ok {
@@ -114,12 +179,28 @@ func (e *Epoller) process(ctx context.Context, fd uintptr) {
 		return
 	}
 	e.mu.Unlock()
-	if i.cg.State() == cgroups.Deleted {
-		e.mu.Lock()
-		delete(e.set, fd)
-		e.mu.Unlock()
-		unix.Close(int(fd))
-		return
+	if i.cg != nil {
+		flush(fd)
+		if i.cg.State() == cgroups.Deleted {
+			e.mu.Lock()
+			e.remove(fd)
+			e.mu.Unlock()
+			return
+		}
+	} else {
+		ignored := flushInotify(fd)
+		n, err := readOOMKill(i.events)
+		if ignored || err != nil {
+			// the watch is dropped once the cgroup is removed
+			e.mu.Lock()
+			e.remove(fd)
+			e.mu.Unlock()
+			return
+		}
+		if n <= i.oomKill {
+			return
+		}
+		i.oomKill = n
 	}
 	if err := e.publisher.Publish(ctx, runtime.TaskOOMEventTopic, &eventstypes.TaskOOM{
 		ContainerID: i.id,
@@ -133,3 +214,40 @@ func flush(fd uintptr) error {
 	_, err := unix.Read(int(fd), buf[:])
 	return err
 }
+
+// flushInotify drains the events of an inotify fd, returning whether the
+// watch was removed
+func flushInotify(fd uintptr) bool {
+	var (
+		buf     [4096]byte
+		ignored bool
+	)
+	for {
+		n, err := unix.Read(int(fd), buf[:])
+		if err != nil || n < unix.SizeofInotifyEvent {
+			return ignored
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			if event.Mask&unix.IN_IGNORED != 0 {
+				ignored = true
+			}
+			off += unix.SizeofInotifyEvent + int(event.Len)
+		}
+	}
+}
+
+// readOOMKill reads the oom_kill counter of a cgroup v2 memory.events file
+func readOOMKill(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
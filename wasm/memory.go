@@ -0,0 +1,114 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/dmcgowan/containerd-wasm/wasm/module"
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxMemoryPagesAnnotation sets the maximum linear memory of a
+	// container's module in 64KiB pages, overriding the memory limit
+	MaxMemoryPagesAnnotation = "io.containerd.wasm.max-memory-pages"
+	// wasmPageSize is the size of a linear memory page
+	wasmPageSize = 65536
+	// wasmMaxPages is the maximum of 32-bit linear memories without one
+	wasmMaxPages = 65536
+)
+
+// maxMemoryPages returns the maximum linear memory of a container's module
+// in pages, from the annotation, else from the memory limit less the engine
+// overhead, else from the default limits of the options. Zero means no
+// maximum.
+func maxMemoryPages(config *Config, spec *specs.Spec, opts *options.Options) (uint32, error) {
+	if v, ok := spec.Annotations[MaxMemoryPagesAnnotation]; ok {
+		pages, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || pages == 0 || pages > wasmMaxPages {
+			return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "%s %q must be a page count between 1 and %d", MaxMemoryPagesAnnotation, v, wasmMaxPages)
+		}
+		return uint32(pages), nil
+	}
+	if limit := specMemoryLimit(spec); limit > 0 {
+		pages := (limit - config.EngineMemoryOverhead) / wasmPageSize
+		if pages < 1 {
+			return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "memory limit of %d bytes leaves no linear memory after the engine overhead of %d bytes", limit, config.EngineMemoryOverhead)
+		}
+		return pagesLimit(uint64(pages)), nil
+	}
+	if opts != nil && opts.Limits != nil && opts.Limits.Memory > 0 {
+		pages := opts.Limits.Memory / wasmPageSize
+		if pages < 1 {
+			return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "default memory limit of %d bytes is less than a page", opts.Limits.Memory)
+		}
+		return pagesLimit(pages), nil
+	}
+	return 0, nil
+}
+
+// specMemoryLimit returns the memory limit of a spec, 0 when it sets none
+func specMemoryLimit(spec *specs.Spec) int64 {
+	if spec.Linux == nil || spec.Linux.Resources == nil || spec.Linux.Resources.Memory == nil {
+		return 0
+	}
+	if limit := spec.Linux.Resources.Memory.Limit; limit != nil && *limit > 0 {
+		return *limit
+	}
+	return 0
+}
+
+// pagesLimit returns 0 for page counts that do not constrain 32-bit
+// memories
+func pagesLimit(pages uint64) uint32 {
+	if pages >= wasmMaxPages {
+		return 0
+	}
+	return uint32(pages)
+}
+
+// validateMemory checks that the module fits in the maximum linear memory
+// of a spec, and warns when the engine cannot enforce it
+func validateMemory(s *Spec) error {
+	if s.MaxMemoryPages == 0 {
+		return nil
+	}
+	m, err := module.Open(s.Module)
+	if err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "failed to parse module %s: %v", s.ModulePath, err)
+	}
+	memories := m.Memories
+	for _, imp := range m.Imports {
+		if imp.Memory != nil {
+			memories = append(memories, *imp.Memory)
+		}
+	}
+	for _, mem := range memories {
+		if mem.Min > uint64(s.MaxMemoryPages) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "module %s needs %d pages of linear memory, over the maximum of %d pages", s.ModulePath, mem.Min, s.MaxMemoryPages)
+		}
+	}
+	if !s.Capabilities.Has(FeatureMemoryLimit) {
+		logrus.WithField("id", s.ID).Warnf("wasm engine %s cannot limit linear memory to %d pages, relying on the cgroup", s.Capabilities.Engine, s.MaxMemoryPages)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package module reads the parts of a wasm binary the shim needs to check
+// a module before running it: its imports, exports, memories and custom
+// sections. Function bodies and other sections are skipped without being
+// validated.
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Kind of an import or export
+type Kind byte
+
+const (
+	// KindFunc is a function
+	KindFunc Kind = 0x00
+	// KindTable is a table
+	KindTable Kind = 0x01
+	// KindMemory is a linear memory
+	KindMemory Kind = 0x02
+	// KindGlobal is a global
+	KindGlobal Kind = 0x03
+)
+
+// MarshalText encodes the kind by name
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k Kind) String() string {
+	switch k {
+	case KindFunc:
+		return "func"
+	case KindTable:
+		return "table"
+	case KindMemory:
+		return "memory"
+	case KindGlobal:
+		return "global"
+	}
+	return fmt.Sprintf("kind(%#x)", byte(k))
+}
+
+// Import of a module
+type Import struct {
+	// Module the import is resolved from
+	Module string `json:"module"`
+	// Name of the import in that module
+	Name string `json:"name"`
+	// Kind of the import
+	Kind Kind `json:"kind"`
+	// Memory limits for KindMemory imports
+	Memory *Memory `json:"memory,omitempty"`
+}
+
+func (i Import) String() string {
+	return fmt.Sprintf("%s.%s (%s)", i.Module, i.Name, i.Kind)
+}
+
+// Export of a module
+type Export struct {
+	// Name of the export
+	Name string `json:"name"`
+	// Kind of the export
+	Kind Kind `json:"kind"`
+}
+
+// Memory limits in 64KiB pages
+type Memory struct {
+	Min uint64 `json:"min"`
+	// Max is only meaningful when HasMax is set
+	Max    uint64 `json:"max,omitempty"`
+	HasMax bool   `json:"has_max"`
+	Shared bool   `json:"shared,omitempty"`
+}
+
+// CustomSection of a module
+type CustomSection struct {
+	Name string `json:"name"`
+	// Size of the section contents in bytes
+	Size int64 `json:"size"`
+}
+
+// Module is the parsed interface of a wasm binary
+type Module struct {
+	Imports []Import
+	Exports []Export
+	// Memories defined by the module, imported memories are in Imports
+	Memories       []Memory
+	CustomSections []CustomSection
+}
+
+// Export returns the export with the given name
+func (m *Module) Export(name string) (Export, bool) {
+	for _, e := range m.Exports {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Export{}, false
+}
+
+// WASI returns the WASI module imported by the module, if any
+func (m *Module) WASI() string {
+	for _, imp := range m.Imports {
+		if imp.Module == "wasi_snapshot_preview1" || imp.Module == "wasi_unstable" {
+			return imp.Module
+		}
+	}
+	return ""
+}
+
+// IsCommand returns true if the module is a WASI command, run once through
+// its _start export, rather than a reactor
+func (m *Module) IsCommand() bool {
+	e, ok := m.Export("_start")
+	return ok && e.Kind == KindFunc
+}
+
+const (
+	sectionCustom = 0
+	sectionImport = 2
+	sectionMemory = 5
+	sectionExport = 7
+)
+
+var header = []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+
+// Open parses the module at path
+func Open(path string) (*Module, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(bufio.NewReader(f))
+}
+
+// Parse reads a binary module
+func Parse(r io.Reader) (*Module, error) {
+	p := &parser{r: r}
+	if _, err := io.ReadFull(r, p.buf[:len(header)]); err != nil {
+		return nil, errors.Wrap(noEOF(err), "failed to read module header")
+	}
+	if !bytes.Equal(p.buf[:len(header)], header) {
+		return nil, errors.New("not a version 1 wasm module")
+	}
+	m := &Module{}
+	for {
+		id, err := p.byte()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		size, err := p.u32()
+		if err != nil {
+			return nil, errors.Wrapf(noEOF(err), "section %d", id)
+		}
+		p.limit = int64(size)
+		switch id {
+		case sectionCustom:
+			err = p.custom(m)
+		case sectionImport:
+			err = p.imports(m)
+		case sectionMemory:
+			err = p.memories(m)
+		case sectionExport:
+			err = p.exports(m)
+		}
+		if err == nil {
+			err = p.skip()
+		}
+		if err != nil {
+			return nil, errors.Wrapf(noEOF(err), "section %d", id)
+		}
+	}
+}
+
+// parser reads one section at a time, limit is what remains of it
+type parser struct {
+	r     io.Reader
+	buf   [8]byte
+	limit int64
+}
+
+func (p *parser) byte() (byte, error) {
+	if _, err := io.ReadFull(p.r, p.buf[:1]); err != nil {
+		return 0, err
+	}
+	p.limit--
+	return p.buf[0], nil
+}
+
+// uleb reads an unsigned LEB128 integer of at most bits bits
+func (p *parser) uleb(bits uint) (uint64, error) {
+	var (
+		v     uint64
+		shift uint
+	)
+	for {
+		b, err := p.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits || (shift+7 > bits && uint64(b&0x7f)>>(bits-shift) != 0) {
+			return 0, errors.New("integer too large")
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+}
+
+func (p *parser) u32() (uint32, error) {
+	v, err := p.uleb(32)
+	return uint32(v), err
+}
+
+func (p *parser) name() (string, error) {
+	n, err := p.u32()
+	if err != nil {
+		return "", err
+	}
+	if int64(n) > p.limit {
+		return "", errors.New("name overruns its section")
+	}
+	// the declared length is not trusted to allocate, truncated modules
+	// fail once the input runs out
+	var b bytes.Buffer
+	if _, err := io.CopyN(&b, p.r, int64(n)); err != nil {
+		return "", err
+	}
+	p.limit -= int64(n)
+	return b.String(), nil
+}
+
+func (p *parser) limits() (Memory, error) {
+	flags, err := p.byte()
+	if err != nil {
+		return Memory{}, err
+	}
+	if flags > 0x07 {
+		return Memory{}, errors.Errorf("invalid limits flags %#x", flags)
+	}
+	bits := uint(32)
+	if flags&0x04 != 0 {
+		bits = 64
+	}
+	m := Memory{HasMax: flags&0x01 != 0, Shared: flags&0x02 != 0}
+	if m.Min, err = p.uleb(bits); err != nil {
+		return Memory{}, err
+	}
+	if m.HasMax {
+		if m.Max, err = p.uleb(bits); err != nil {
+			return Memory{}, err
+		}
+	}
+	return m, nil
+}
+
+func (p *parser) imports(m *Module) error {
+	n, err := p.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = p.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = p.name(); err != nil {
+			return err
+		}
+		kind, err := p.byte()
+		if err != nil {
+			return err
+		}
+		imp.Kind = Kind(kind)
+		switch imp.Kind {
+		case KindFunc:
+			_, err = p.u32()
+		case KindTable:
+			if _, err = p.byte(); err == nil {
+				_, err = p.limits()
+			}
+		case KindMemory:
+			var mem Memory
+			if mem, err = p.limits(); err == nil {
+				imp.Memory = &mem
+			}
+		case KindGlobal:
+			if _, err = p.byte(); err == nil {
+				_, err = p.byte()
+			}
+		default:
+			err = errors.Errorf("invalid import kind %#x", kind)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "import %s.%s", imp.Module, imp.Name)
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func (p *parser) memories(m *Module) error {
+	n, err := p.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		mem, err := p.limits()
+		if err != nil {
+			return err
+		}
+		m.Memories = append(m.Memories, mem)
+	}
+	return nil
+}
+
+func (p *parser) custom(m *Module) error {
+	name, err := p.name()
+	if err != nil {
+		return err
+	}
+	m.CustomSections = append(m.CustomSections, CustomSection{Name: name, Size: p.limit})
+	return nil
+}
+
+func (p *parser) exports(m *Module) error {
+	n, err := p.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var e Export
+		if e.Name, err = p.name(); err != nil {
+			return err
+		}
+		kind, err := p.byte()
+		if err != nil {
+			return err
+		}
+		if _, err := p.u32(); err != nil {
+			return err
+		}
+		e.Kind = Kind(kind)
+		m.Exports = append(m.Exports, e)
+	}
+	return nil
+}
+
+// skip discards the rest of the current section
+func (p *parser) skip() error {
+	if p.limit < 0 {
+		return errors.New("section overruns its declared size")
+	}
+	n, err := io.CopyN(ioutil.Discard, p.r, p.limit)
+	p.limit -= n
+	return err
+}
+
+// noEOF reports a truncated module as such
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,238 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package module
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// uleb encodes v as an unsigned LEB128 integer
+func uleb(v uint64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// name encodes a length prefixed name
+func name(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+// section encodes a section with its declared size
+func section(id byte, content ...[]byte) []byte {
+	c := bytes.Join(content, nil)
+	return append(append([]byte{id}, uleb(uint64(len(c)))...), c...)
+}
+
+// binary prefixes sections with the module header
+func binary(sections ...[]byte) []byte {
+	return bytes.Join(append([][]byte{header}, sections...), nil)
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		bin  []byte
+		// expected module, or error when err is set
+		module *Module
+		err    string
+		// expected cause of the error, when set
+		cause error
+	}{
+		{
+			name: "wasi command",
+			bin: binary(
+				section(1, []byte{1, 0x60, 0, 0}),
+				section(sectionImport, uleb(1), name("wasi_snapshot_preview1"), name("fd_write"), []byte{byte(KindFunc), 0}),
+				section(3, []byte{1, 0}),
+				section(sectionMemory, uleb(1), []byte{0x01}, uleb(2), uleb(16)),
+				section(sectionExport, uleb(2), name("memory"), []byte{byte(KindMemory), 0}, name("_start"), []byte{byte(KindFunc), 1}),
+				section(sectionCustom, name("producers"), []byte{1, 2, 3}),
+			),
+			module: &Module{
+				Imports:        []Import{{Module: "wasi_snapshot_preview1", Name: "fd_write", Kind: KindFunc}},
+				Exports:        []Export{{Name: "memory", Kind: KindMemory}, {Name: "_start", Kind: KindFunc}},
+				Memories:       []Memory{{Min: 2, Max: 16, HasMax: true}},
+				CustomSections: []CustomSection{{Name: "producers", Size: 3}},
+			},
+		},
+		{
+			name: "wasi reactor",
+			bin: binary(
+				section(sectionImport, uleb(2),
+					name("wasi_snapshot_preview1"), name("proc_exit"), []byte{byte(KindFunc), 0},
+					name("env"), name("memory"), []byte{byte(KindMemory), 0x00}, uleb(1)),
+				section(sectionExport, uleb(1), name("_initialize"), []byte{byte(KindFunc), 1}),
+			),
+			module: &Module{
+				Imports: []Import{
+					{Module: "wasi_snapshot_preview1", Name: "proc_exit", Kind: KindFunc},
+					{Module: "env", Name: "memory", Kind: KindMemory, Memory: &Memory{Min: 1}},
+				},
+				Exports: []Export{{Name: "_initialize", Kind: KindFunc}},
+			},
+		},
+		{
+			name:   "empty",
+			bin:    binary(),
+			module: &Module{},
+		},
+		{
+			name: "not wasm",
+			bin:  []byte("#!/bin/sh\necho hello\n"),
+			err:  "not a version 1 wasm module",
+		},
+		{
+			name:  "truncated header",
+			bin:   header[:5],
+			err:   "failed to read module header",
+			cause: io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "truncated section size",
+			bin:   binary([]byte{sectionExport, 0x80}),
+			cause: io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "truncated section",
+			bin:   binary(section(sectionExport, uleb(1), name("_start"), []byte{byte(KindFunc), 0})[:8]),
+			cause: io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "truncated skipped section",
+			bin:   binary(section(10, make([]byte, 32))[:16]),
+			cause: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "oversized section size",
+			bin:  binary([]byte{sectionCustom, 0xff, 0xff, 0xff, 0xff, 0x1f}),
+			err:  "integer too large",
+		},
+		{
+			name: "overlong section size",
+			bin:  binary([]byte{sectionCustom, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}),
+			err:  "integer too large",
+		},
+		{
+			name: "oversized memory limit",
+			bin:  binary(section(sectionMemory, uleb(1), []byte{0x00}, uleb(1<<32))),
+			err:  "integer too large",
+		},
+		{
+			name: "name longer than section",
+			bin:  binary(section(sectionCustom, uleb(64), []byte("name"))),
+			err:  "name overruns its section",
+		},
+		{
+			name: "huge name length",
+			bin:  binary(section(sectionExport, uleb(1), uleb(0xffffffff))),
+			err:  "name overruns its section",
+		},
+		{
+			name: "section past declared size",
+			// a memory with a maximum declared in two bytes
+			bin: binary([]byte{sectionMemory, 2, 1, 0x01, 0x01, 0x02}),
+			err: "section overruns its declared size",
+		},
+		{
+			name: "invalid import kind",
+			bin:  binary(section(sectionImport, uleb(1), name("env"), name("f"), []byte{0x04})),
+			err:  "invalid import kind",
+		},
+		{
+			name: "invalid limits flags",
+			bin:  binary(section(sectionMemory, uleb(1), []byte{0x08, 0x01})),
+			err:  "invalid limits flags",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := Parse(bytes.NewReader(tc.bin))
+			if tc.module != nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(m, tc.module) {
+					t.Fatalf("expected %+v, parsed %+v", tc.module, m)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error, parsed %+v", m)
+			}
+			if tc.err != "" && !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q, got %v", tc.err, err)
+			}
+			if tc.cause != nil && errors.Cause(err) != tc.cause {
+				t.Fatalf("expected %v, got %v", tc.cause, err)
+			}
+		})
+	}
+}
+
+func TestModuleKind(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		module  Module
+		command bool
+		wasi    string
+	}{
+		{
+			name: "command",
+			module: Module{
+				Imports: []Import{{Module: "wasi_snapshot_preview1", Name: "fd_write", Kind: KindFunc}},
+				Exports: []Export{{Name: "_start", Kind: KindFunc}},
+			},
+			command: true,
+			wasi:    "wasi_snapshot_preview1",
+		},
+		{
+			name: "reactor",
+			module: Module{
+				Imports: []Import{{Module: "wasi_unstable", Name: "fd_write", Kind: KindFunc}},
+				Exports: []Export{{Name: "_initialize", Kind: KindFunc}},
+			},
+			wasi: "wasi_unstable",
+		},
+		{
+			name: "start global",
+			module: Module{
+				Exports: []Export{{Name: "_start", Kind: KindGlobal}},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if command := tc.module.IsCommand(); command != tc.command {
+				t.Fatalf("expected command %v, got %v", tc.command, command)
+			}
+			if wasi := tc.module.WASI(); wasi != tc.wasi {
+				t.Fatalf("expected wasi %q, got %q", tc.wasi, wasi)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/mount"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// preopensDirName holds the read-only bind mounts of preopened directories
+// for engines unable to restrict access themselves
+const preopensDirName = "preopens"
+
+// mountDirs translates the bind mounts of an OCI spec into preopened
+// directories. Mounts without a host directory to preopen are skipped.
+func mountDirs(config *Config, id string, spec *specs.Spec) ([]Dir, error) {
+	var dirs []Dir
+	for _, m := range spec.Mounts {
+		log := logrus.WithFields(logrus.Fields{"id": id, "destination": m.Destination})
+		if !isBind(m) {
+			log.Infof("skipping %s mount, only bind mounts can be preopened", m.Type)
+			continue
+		}
+		guest := filepath.Clean("/" + m.Destination)
+		if guest == "/" {
+			log.Info("skipping bind mount over the root filesystem")
+			continue
+		}
+		host, err := filepath.EvalSymlinks(m.Source)
+		if err != nil {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "failed to resolve mount source %s: %v", m.Source, err)
+		}
+		if fi, err := os.Stat(host); err != nil || !fi.IsDir() {
+			log.Infof("skipping bind mount of %s, only directories can be preopened", m.Source)
+			continue
+		}
+		if !allowedPreopen(config.AllowedHostPreopens, host) {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "mount source %s is not in allowed_host_preopens", m.Source)
+		}
+		dirs = append(dirs, Dir{
+			Host:     host,
+			Guest:    guest,
+			ReadOnly: isReadOnly(m.Options),
+		})
+	}
+	return dirs, nil
+}
+
+// isBind checks whether a mount binds a host path into the container
+func isBind(m specs.Mount) bool {
+	if m.Type == "bind" {
+		return true
+	}
+	for _, o := range m.Options {
+		if o == "bind" || o == "rbind" {
+			return true
+		}
+	}
+	return false
+}
+
+// isReadOnly checks the mount options for ro, the last of ro and rw wins
+func isReadOnly(options []string) bool {
+	ro := false
+	for _, o := range options {
+		switch o {
+		case "ro":
+			ro = true
+		case "rw":
+			ro = false
+		}
+	}
+	return ro
+}
+
+// allowedPreopen checks a resolved host directory against the allowed
+// preopens of the configuration, an empty list allowing any directory
+func allowedPreopen(allowed []string, host string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, dir := range allowed {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		rel, err := filepath.Rel(dir, host)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDirs checks that the engine can enforce the read-only
+// directories of a spec
+func validateDirs(s *Spec) error {
+	for _, d := range s.Dirs {
+		if d.ReadOnly && !s.Capabilities.Has(FeatureReadOnlyDirs) {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "wasm engine %s cannot preopen %s read-only", s.Capabilities.Engine, d.Guest)
+		}
+	}
+	return nil
+}
+
+// canBindMount checks whether the shim can bind mount read-only
+// directories for engines without read-only preopens
+func canBindMount() bool {
+	return os.Geteuid() == 0
+}
+
+// bindReadOnlyDirs replaces the read-only directories of a spec with
+// read-only bind mounts under the bundle, so engines without read-only
+// preopens cannot write to them
+func bindReadOnlyDirs(s *Spec) error {
+	for i, d := range s.Dirs {
+		if !d.ReadOnly {
+			continue
+		}
+		target := filepath.Join(s.Bundle, preopensDirName, strconv.Itoa(i))
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return err
+		}
+		m := mount.Mount{
+			Type:    "bind",
+			Source:  d.Host,
+			Options: []string{"bind", "ro"},
+		}
+		if err := m.Mount(target); err != nil {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "failed to mount %s read-only: %v", d.Guest, err)
+		}
+		s.Dirs[i].Host = target
+	}
+	return nil
+}
+
+// UnmountPreopens removes the read-only bind mounts of a bundle's
+// preopened directories
+func UnmountPreopens(bundle string) error {
+	dir := filepath.Join(bundle, preopensDirName)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		if err := mount.UnmountAll(path, 0); err != nil {
+			return errors.Wrapf(err, "failed to unmount preopen %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return os.Remove(dir)
+}
@@ -0,0 +1,116 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package options contains the runtime options understood by the wasm shim.
+// The messages mirror options.proto and are encoded by the proto library
+// from their struct tags.
+package options
+
+import (
+	"github.com/BurntSushi/toml"
+	runcoptions "github.com/containerd/containerd/runtime/v2/runc/options"
+	"github.com/containerd/typeurl"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	proto.RegisterType((*Options)(nil), "containerd.wasm.v1.Options")
+	proto.RegisterType((*Limits)(nil), "containerd.wasm.v1.Limits")
+	proto.RegisterType((*criOptions)(nil), "cri.runtimeoptions.v1.Options")
+}
+
+// Options for the wasm shim, passed with each task
+type Options struct {
+	// Engine used to run modules, one of wasmer, wasmtime or wazero
+	Engine string `protobuf:"bytes,1,opt,name=engine,proto3" json:"engine,omitempty" toml:"engine"`
+	// EngineBinary is the path of the engine binary for command line engines
+	EngineBinary string `protobuf:"bytes,2,opt,name=engine_binary,json=engineBinary,proto3" json:"engine_binary,omitempty" toml:"engine_binary"`
+	// EngineArgs are extra arguments passed to the engine before the module
+	EngineArgs []string `protobuf:"bytes,3,rep,name=engine_args,json=engineArgs,proto3" json:"engine_args,omitempty" toml:"engine_args"`
+	// CacheDir is the directory compiled modules are cached in
+	CacheDir string `protobuf:"bytes,4,opt,name=cache_dir,json=cacheDir,proto3" json:"cache_dir,omitempty" toml:"cache_dir"`
+	// Limits applied to containers that set none
+	Limits *Limits `protobuf:"bytes,5,opt,name=limits,proto3" json:"limits,omitempty" toml:"limits"`
+}
+
+func (m *Options) Reset()         { *m = Options{} }
+func (m *Options) String() string { return proto.CompactTextString(m) }
+func (*Options) ProtoMessage()    {}
+
+// Limits for a container
+type Limits struct {
+	// Memory is the maximum linear memory of a module in bytes
+	Memory uint64 `protobuf:"varint,1,opt,name=memory,proto3" json:"memory,omitempty" toml:"memory"`
+}
+
+func (m *Limits) Reset()         { *m = Limits{} }
+func (m *Limits) String() string { return proto.CompactTextString(m) }
+func (*Limits) ProtoMessage()    {}
+
+// criOptions mirrors cri.runtimeoptions.v1.Options which the CRI plugin
+// sends for runtime types it does not know, pointing at a TOML file
+// configured through the runtime's options.ConfigPath
+type criOptions struct {
+	TypeURL    string `protobuf:"bytes,1,opt,name=type_url,json=typeUrl,proto3" json:"type_url,omitempty"`
+	ConfigPath string `protobuf:"bytes,2,opt,name=config_path,json=configPath,proto3" json:"config_path,omitempty"`
+}
+
+func (m *criOptions) Reset()         { *m = criOptions{} }
+func (m *criOptions) String() string { return proto.CompactTextString(m) }
+func (*criOptions) ProtoMessage()    {}
+
+// Unmarshal decodes the options of a task. Besides Options it accepts the
+// runc options, whose binary name selects the engine binary, and the CRI
+// runtime options, whose config path names a TOML encoded Options file.
+func Unmarshal(any *types.Any) (*Options, error) {
+	if any == nil {
+		return &Options{}, nil
+	}
+	v, err := typeurl.UnmarshalAny(any)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal options")
+	}
+	switch o := v.(type) {
+	case *Options:
+		return o, nil
+	case *runcoptions.Options:
+		return &Options{
+			EngineBinary: o.BinaryName,
+		}, nil
+	case *criOptions:
+		if o.ConfigPath == "" {
+			return &Options{}, nil
+		}
+		return Load(o.ConfigPath)
+	default:
+		return nil, errors.Errorf("unsupported options type %s", any.TypeUrl)
+	}
+}
+
+// Load reads TOML encoded options from a file
+func Load(path string) (*Options, error) {
+	var o Options
+	md, err := toml.DecodeFile(path, &o)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load options from %s", path)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, errors.Errorf("unknown keys in options file %s: %v", path, undecoded)
+	}
+	return &o, nil
+}
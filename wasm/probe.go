@@ -0,0 +1,160 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+// Feature of an engine
+type Feature string
+
+const (
+	// FeatureWASIUnstable is support for the wasi_unstable imports
+	FeatureWASIUnstable Feature = "wasi_unstable"
+	// FeatureWASIPreview1 is support for the wasi_snapshot_preview1 imports
+	FeatureWASIPreview1 Feature = "wasi_snapshot_preview1"
+	// FeatureReadOnlyDirs is support for read-only preopened directories
+	FeatureReadOnlyDirs Feature = "read_only_dirs"
+	// FeatureMemoryLimit is support for limiting the linear memory of modules
+	FeatureMemoryLimit Feature = "memory_limit"
+)
+
+// Capabilities of an engine as installed on the node
+type Capabilities struct {
+	// Engine name
+	Engine string `json:"engine"`
+	// Binary the engine runs, empty for in-process engines
+	Binary string `json:"binary,omitempty"`
+	// Version of the engine
+	Version string `json:"version"`
+	// Features supported by the engine
+	Features []Feature `json:"features"`
+}
+
+// Has returns true if the engine supports the feature
+func (c *Capabilities) Has(f Feature) bool {
+	for _, feature := range c.Features {
+		if feature == f {
+			return true
+		}
+	}
+	return false
+}
+
+// Prober is implemented by engines that can report their capabilities
+type Prober interface {
+	// Probe the engine used to run the spec
+	Probe(ctx context.Context, s *Spec) (*Capabilities, error)
+}
+
+const probeTimeout = 10 * time.Second
+
+// probe of an engine binary, done is closed once caps or err are set
+type probe struct {
+	done chan struct{}
+	caps *Capabilities
+	err  error
+}
+
+var (
+	probesMu sync.Mutex
+	probes   = make(map[string]*probe)
+)
+
+// ProbeEngine returns the capabilities of the engine used to run the spec.
+// Each engine binary is probed once per shim process, failed probes are
+// retried by the next call. Engines that cannot be used fail with
+// errdefs.ErrFailedPrecondition.
+func ProbeEngine(ctx context.Context, e Engine, s *Spec) (*Capabilities, error) {
+	p, ok := e.(Prober)
+	if !ok {
+		return &Capabilities{Engine: e.Name()}, nil
+	}
+	key := e.Name() + "\x00" + s.binary(e.Name())
+
+	probesMu.Lock()
+	r, ok := probes[key]
+	if !ok {
+		r = &probe{done: make(chan struct{})}
+		probes[key] = r
+	}
+	probesMu.Unlock()
+	if !ok {
+		// the result is shared with other requests, which must not be
+		// failed by the cancellation of this one
+		pctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		r.caps, r.err = p.Probe(pctx, s)
+		cancel()
+		if r.err != nil {
+			r.err = errors.Wrapf(errdefs.ErrFailedPrecondition, "wasm engine %s is unusable: %v", e.Name(), r.err)
+			probesMu.Lock()
+			delete(probes, key)
+			probesMu.Unlock()
+		}
+		close(r.done)
+	}
+	select {
+	case <-r.done:
+		return r.caps, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var versionRegexp = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+// commandVersion runs `binary --version` and returns the version it reports
+func commandVersion(ctx context.Context, binary string) (string, error) {
+	out, err := exec.CommandContext(ctx, binary, "--version").CombinedOutput()
+	if err != nil {
+		if ee, ok := err.(*exec.Error); ok {
+			return "", errors.Wrapf(ee.Err, "binary %s", binary)
+		}
+		return "", errors.Wrapf(err, "%s --version: %s", binary, strings.TrimSpace(string(out)))
+	}
+	version := versionRegexp.FindString(string(out))
+	if version == "" {
+		return "", errors.Errorf("no version in %s --version output %q", binary, strings.TrimSpace(string(out)))
+	}
+	return version, nil
+}
+
+// versionAtLeast compares dotted numeric versions
+func versionAtLeast(version, min string) bool {
+	v, m := strings.Split(version, "."), strings.Split(min, ".")
+	for i := range m {
+		var a, b int
+		if i < len(v) {
+			a, _ = strconv.Atoi(v[i])
+		}
+		b, _ = strconv.Atoi(m[i])
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
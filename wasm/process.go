@@ -4,7 +4,6 @@ import (
 	"context"
 	"io"
 	"os"
-	"os/exec"
 	"sync"
 	"syscall"
 	"time"
@@ -17,27 +16,24 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	wasmRuntime = "wasmer"
-)
-
 type process struct {
 	mu sync.Mutex
 
 	id         string
 	exitStatus int
+	exitReason string
 	exitTime   time.Time
 	stdio      stdio.Stdio
 	stdin      io.Closer
-	process    *os.Process
+	instance   Instance
 	exited     chan struct{}
 	ec         chan<- Exit
 
-	rootfs string
-	env    []string
-	args   []string
-
-	isSandbox bool
+	engine Engine
+	spec   *Spec
+	// pauseState is pausing or paused while the container's cgroup is
+	// being or is frozen
+	pauseState string
 
 	waitError error
 }
@@ -49,18 +45,32 @@ func (p *process) ID() string {
 func (p *process) Pid() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.process != nil {
-		return p.process.Pid
+	if p.instance != nil {
+		return p.instance.Pid()
 	}
 	return 0
 }
 
+// Instance of the module, nil until the process is started
+func (p *process) Instance() Instance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.instance
+}
+
 func (p *process) ExitStatus() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	return p.exitStatus
 }
 
+// ExitReason of the process when known, such as ExitReasonMemoryExhausted
+func (p *process) ExitReason() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.exitReason
+}
+
 func (p *process) ExitedAt() time.Time {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -82,8 +92,12 @@ func (p *process) Status(context.Context) (string, error) {
 	case <-p.exited:
 	default:
 		p.mu.Lock()
-		running := p.process != nil
+		running := p.instance != nil
+		pauseState := p.pauseState
 		p.mu.Unlock()
+		if pauseState != "" {
+			return pauseState, nil
+		}
 		if running {
 			return "running", nil
 		}
@@ -102,27 +116,11 @@ func (p *process) Resize(ws console.WinSize) error {
 }
 
 func (p *process) Start(ctx context.Context) (err error) {
-
-	var cmd *exec.Cmd
-	// If this is a sandbox, run a normal process
-	if p.isSandbox {
-		cmd = exec.Command(p.args[0])
-		if len(p.args) > 1 {
-			cmd = exec.Command(p.args[0], p.args[1:]...)
-		}
-	} else {
-		var args []string
-		// remap root
-		args = append(args, "--mapdir=/:"+p.rootfs)
-		for _, env := range p.env {
-			args = append(args, "--env="+env)
-		}
-		args = append(args, p.args...)
-		cmd = exec.Command(wasmRuntime, args...)
-	}
-
-	var in io.Closer
-	var closers []io.Closer
+	var (
+		sio     IO
+		in      io.Closer
+		closers []io.Closer
+	)
 	if p.stdio.Stdin != "" {
 		stdin, err := os.OpenFile(p.stdio.Stdin, os.O_RDONLY, 0)
 		if err != nil {
@@ -133,7 +131,7 @@ func (p *process) Start(ctx context.Context) (err error) {
 				stdin.Close()
 			}
 		}()
-		cmd.Stdin = stdin
+		sio.Stdin = stdin
 		in = stdin
 		closers = append(closers, stdin)
 	}
@@ -148,7 +146,7 @@ func (p *process) Start(ctx context.Context) (err error) {
 				stdout.Close()
 			}
 		}()
-		cmd.Stdout = stdout
+		sio.Stdout = stdout
 		closers = append(closers, stdout)
 	}
 
@@ -162,45 +160,53 @@ func (p *process) Start(ctx context.Context) (err error) {
 				stderr.Close()
 			}
 		}()
-		cmd.Stderr = stderr
+		sio.Stderr = stderr
 		closers = append(closers, stderr)
 	}
 
 	p.mu.Lock()
-	if p.process != nil {
+	if p.instance != nil {
 		p.mu.Unlock()
 		return errors.Wrap(errdefs.ErrFailedPrecondition, "already running")
 	}
-	if err := cmd.Start(); err != nil {
+	instance, err := p.engine.Start(ctx, p.spec, sio)
+	if err != nil {
 		p.mu.Unlock()
 		return err
 	}
-	p.process = cmd.Process
+	p.instance = instance
 	p.stdin = in
 	p.mu.Unlock()
 
 	log := log.GetLogger(context.TODO())
-	log.Infof("wasm Start: %d", p.Pid())
+	log.Infof("wasm Start: %d (%s)", p.Pid(), p.engine.Name())
 
 	go func() {
-		waitStatus, err := p.process.Wait()
+		status, err := instance.Wait()
 		p.mu.Lock()
 		p.exitTime = time.Now()
+		p.exitStatus = status
 		if err != nil {
 			p.exitStatus = -1
 			logrus.WithError(err).Errorf("wait returned error")
-		} else if waitStatus != nil {
-			// TODO: Make this cross platform
-			p.exitStatus = int(waitStatus.Sys().(syscall.WaitStatus))
+		}
+		if r, ok := instance.(ExitReasoner); ok {
+			p.exitReason = r.ExitReason()
 		}
 		p.mu.Unlock()
 
 		close(p.exited)
 
-		p.ec <- Exit{
+		e := Exit{
+			ID:     p.id,
 			Pid:    p.Pid(),
-			Status: p.exitStatus,
+			Status: p.ExitStatus(),
+			Reason: p.ExitReason(),
 		}
+		if e.Reason != "" {
+			logrus.WithField("id", p.id).Warnf("wasm module exited with status %d: %s", e.Status, e.Reason)
+		}
+		p.ec <- e
 
 		for _, c := range closers {
 			c.Close()
@@ -219,7 +225,7 @@ func (p *process) Kill(ctx context.Context, signal uint32, all bool) error {
 	defer p.mu.Unlock()
 
 	// Verify process was started
-	if p.process == nil {
+	if p.instance == nil {
 		return errors.New("process not started")
 	}
 
@@ -234,13 +240,31 @@ func (p *process) Kill(ctx context.Context, signal uint32, all bool) error {
 	}
 
 	// Send signal to process
-	if err := p.process.Signal(syscall.Signal(signal)); err != nil && err.Error() != "process already finished" {
+	if err := p.instance.Signal(syscall.Signal(signal)); err != nil && err.Error() != "process already finished" {
 		return err
 	}
 
 	return nil
 }
 
+// Stats of the running instance
+func (p *process) Stats(ctx context.Context) (interface{}, error) {
+	p.mu.Lock()
+	instance := p.instance
+	p.mu.Unlock()
+	if instance == nil {
+		return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "process not started")
+	}
+	return instance.Stats(ctx)
+}
+
+// setPauseState records the state of the container's freezer
+func (p *process) setPauseState(state string) {
+	p.mu.Lock()
+	p.pauseState = state
+	p.mu.Unlock()
+}
+
 func (p *process) SetExited(status int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
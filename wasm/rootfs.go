@@ -0,0 +1,156 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+// maxSymlinks followed while resolving a single path
+const maxSymlinks = 255
+
+// resolveInRoot resolves a guest path to a host path inside root. Symlinks
+// are followed as securejoin does, with absolute targets taken relative to
+// root. Unlike securejoin, which clamps them, paths that would climb above
+// root fail with errdefs.ErrInvalidArgument. Components that do not exist
+// are joined lexically.
+func resolveInRoot(root, path string) (string, error) {
+	var (
+		resolved []string
+		unsafe   = path
+		links    int
+	)
+	for unsafe != "" {
+		var component string
+		if i := strings.IndexByte(unsafe, '/'); i >= 0 {
+			component, unsafe = unsafe[:i], unsafe[i+1:]
+		} else {
+			component, unsafe = unsafe, ""
+		}
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", errors.Wrapf(errdefs.ErrInvalidArgument, "%s escapes the container root", path)
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		next := append(resolved, component)
+		fi, err := os.Lstat(filepath.Join(root, filepath.Join(next...)))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", errors.Wrapf(errdefs.ErrInvalidArgument, "%s: %v", path, syscall.ELOOP)
+		}
+		dest, err := os.Readlink(filepath.Join(root, filepath.Join(next...)))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(dest) {
+			resolved = nil
+		}
+		unsafe = dest + "/" + unsafe
+	}
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
+
+// resolveFile resolves a guest path to a regular file inside root
+func resolveFile(root, path string) (string, error) {
+	host, err := resolveInRoot(root, path)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Lstat(host)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Wrapf(errdefs.ErrNotFound, "%s does not exist in the container root", path)
+		}
+		return "", err
+	}
+	if !fi.Mode().IsRegular() {
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "%s is not a regular file", path)
+	}
+	return host, nil
+}
+
+// resolveDir resolves a guest path to a directory inside the preopened
+// directory of a spec holding it. The guest path with symlinks resolved is
+// returned. Missing directories are only allowed in writable preopens, for
+// createCwd to create.
+func resolveDir(s *Spec, path string) (string, error) {
+	path = filepath.Clean("/" + path)
+	dir, rel, ok := s.preopen(path)
+	if !ok {
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "%s is not in a preopened directory", path)
+	}
+	host, err := resolveInRoot(dir.Host, rel)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(host)
+	switch {
+	case os.IsNotExist(err) && !dir.ReadOnly:
+	case os.IsNotExist(err):
+		return "", errors.Wrapf(errdefs.ErrNotFound, "%s does not exist in a read-only directory", path)
+	case err != nil:
+		return "", err
+	case !fi.IsDir():
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "%s is not a directory", path)
+	}
+	rel, err = filepath.Rel(dir.Host, host)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join("/", dir.Guest, rel), nil
+}
+
+// createCwd creates the working directory of a spec when it is missing, as
+// runc does
+func createCwd(s *Spec) error {
+	if s.Cwd == "" {
+		return nil
+	}
+	host, ok := s.hostPath(s.Cwd)
+	if !ok {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "working directory %s is not in a preopened directory", s.Cwd)
+	}
+	if err := os.MkdirAll(host, 0755); err != nil {
+		return errors.Wrap(err, "failed to create working directory")
+	}
+	return nil
+}
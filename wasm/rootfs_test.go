@@ -0,0 +1,147 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// testRootfs creates an image layout below a directory, next to a file
+// outside of it which must never be resolved
+func testRootfs(t *testing.T) string {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "rootfs")
+	for _, d := range []string{"usr/bin", "app", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"usr/bin/app.wasm", "app/main.wasm", "../outside.wasm"} {
+		if err := ioutil.WriteFile(filepath.Join(root, f), []byte("\x00asm"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"bin":              "usr/bin",
+		"abs":              "/usr/bin/app.wasm",
+		"escape":           "../outside.wasm",
+		"escape-deep":      "usr/../../outside.wasm",
+		"escape-abs":       "/../outside.wasm",
+		"escape-dir":       "..",
+		"usr/bin/up":       "../../..",
+		"loop-a":           "loop-b",
+		"loop-b":           "loop-a",
+		"self":             "self/x",
+		"app/link.wasm":    "../usr/bin/app.wasm",
+		"dir-link":         "empty",
+		"dangling":         "missing.wasm",
+		"host-passwd":      "/etc/passwd",
+		"usr/bin/sibling":  "app.wasm",
+		"usr/bin/root-rel": "/app/main.wasm",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestResolveFile(t *testing.T) {
+	root := testRootfs(t)
+	for _, tc := range []struct {
+		path string
+		// expected host path relative to root, empty when check fails
+		host  string
+		check func(error) bool
+	}{
+		{path: "/usr/bin/app.wasm", host: "usr/bin/app.wasm"},
+		{path: "usr/bin/app.wasm", host: "usr/bin/app.wasm"},
+		{path: "/usr/./bin//app.wasm", host: "usr/bin/app.wasm"},
+		{path: "/app/../usr/bin/app.wasm", host: "usr/bin/app.wasm"},
+		{path: "/bin/app.wasm", host: "usr/bin/app.wasm"},
+		{path: "/abs", host: "usr/bin/app.wasm"},
+		{path: "/app/link.wasm", host: "usr/bin/app.wasm"},
+		{path: "/bin/sibling", host: "usr/bin/app.wasm"},
+		{path: "/bin/root-rel", host: "app/main.wasm"},
+
+		// escapes
+		{path: "../outside.wasm", check: errdefs.IsInvalidArgument},
+		{path: "/../outside.wasm", check: errdefs.IsInvalidArgument},
+		{path: "/app/../../outside.wasm", check: errdefs.IsInvalidArgument},
+		{path: "/escape", check: errdefs.IsInvalidArgument},
+		{path: "/escape-deep", check: errdefs.IsInvalidArgument},
+		{path: "/escape-abs", check: errdefs.IsInvalidArgument},
+		{path: "/escape-dir/outside.wasm", check: errdefs.IsInvalidArgument},
+		{path: "/bin/up/outside.wasm", check: errdefs.IsInvalidArgument},
+		{path: "/usr/bin/up/../outside.wasm", check: errdefs.IsInvalidArgument},
+
+		// absolute targets stay in the root
+		{path: "/host-passwd", check: errdefs.IsNotFound},
+
+		// loops
+		{path: "/loop-a", check: errdefs.IsInvalidArgument},
+		{path: "/self", check: errdefs.IsInvalidArgument},
+
+		// missing and non-regular targets
+		{path: "/missing.wasm", check: errdefs.IsNotFound},
+		{path: "/dangling", check: errdefs.IsNotFound},
+		{path: "/usr/missing/app.wasm", check: errdefs.IsNotFound},
+		{path: "/", check: errdefs.IsInvalidArgument},
+		{path: "/app", check: errdefs.IsInvalidArgument},
+		{path: "/dir-link", check: errdefs.IsInvalidArgument},
+		{path: "/bin", check: errdefs.IsInvalidArgument},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			host, err := resolveFile(root, tc.path)
+			if tc.check != nil {
+				if err == nil {
+					t.Fatalf("expected error, resolved to %s", host)
+				}
+				if !tc.check(err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := filepath.Join(root, tc.host); host != expected {
+				t.Fatalf("expected %s, resolved to %s", expected, host)
+			}
+		})
+	}
+}
+
+func TestResolveInRootMissing(t *testing.T) {
+	root := testRootfs(t)
+	// missing components are joined lexically after the resolved parent
+	host, err := resolveInRoot(root, "/bin/missing/app.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(root, "usr/bin/missing/app.wasm"); host != expected {
+		t.Fatalf("expected %s, resolved to %s", expected, host)
+	}
+	if _, err := resolveInRoot(root, "/missing/../../outside.wasm"); !errdefs.IsInvalidArgument(err) {
+		t.Fatalf("expected invalid argument, got %v", err)
+	}
+}
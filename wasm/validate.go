@@ -0,0 +1,154 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/dmcgowan/containerd-wasm/wasm/module"
+	"github.com/pkg/errors"
+)
+
+// startExport is the entrypoint of WASI commands
+const startExport = "_start"
+
+// wasiFunctions are the functions of the wasi_snapshot_preview1 module,
+// wasi_unstable has the same ones save for sock_accept
+var wasiFunctions = map[string]bool{
+	"args_get":                true,
+	"args_sizes_get":          true,
+	"environ_get":             true,
+	"environ_sizes_get":       true,
+	"clock_res_get":           true,
+	"clock_time_get":          true,
+	"fd_advise":               true,
+	"fd_allocate":             true,
+	"fd_close":                true,
+	"fd_datasync":             true,
+	"fd_fdstat_get":           true,
+	"fd_fdstat_set_flags":     true,
+	"fd_fdstat_set_rights":    true,
+	"fd_filestat_get":         true,
+	"fd_filestat_set_size":    true,
+	"fd_filestat_set_times":   true,
+	"fd_pread":                true,
+	"fd_prestat_get":          true,
+	"fd_prestat_dir_name":     true,
+	"fd_pwrite":               true,
+	"fd_read":                 true,
+	"fd_readdir":              true,
+	"fd_renumber":             true,
+	"fd_seek":                 true,
+	"fd_sync":                 true,
+	"fd_tell":                 true,
+	"fd_write":                true,
+	"path_create_directory":   true,
+	"path_filestat_get":       true,
+	"path_filestat_set_times": true,
+	"path_link":               true,
+	"path_open":               true,
+	"path_readlink":           true,
+	"path_remove_directory":   true,
+	"path_rename":             true,
+	"path_symlink":            true,
+	"path_unlink_file":        true,
+	"poll_oneoff":             true,
+	"proc_exit":               true,
+	"proc_raise":              true,
+	"sched_yield":             true,
+	"random_get":              true,
+	"sock_accept":             true,
+	"sock_recv":               true,
+	"sock_send":               true,
+	"sock_shutdown":           true,
+}
+
+// ModuleError lists what a module needs that an engine does not provide.
+// Its cause is errdefs.ErrFailedPrecondition.
+type ModuleError struct {
+	// Module is the guest path of the module
+	Module string
+	// Engine the module was checked against
+	Engine string
+	// Imports the engine cannot satisfy
+	Imports []module.Import
+	// Exports the module is missing
+	Exports []string
+}
+
+func (e *ModuleError) Error() string {
+	var missing []string
+	if len(e.Imports) > 0 {
+		imports := make([]string, len(e.Imports))
+		for i, imp := range e.Imports {
+			imports[i] = imp.String()
+		}
+		missing = append(missing, "missing imports "+strings.Join(imports, ", "))
+	}
+	if len(e.Exports) > 0 {
+		missing = append(missing, "missing exports "+strings.Join(e.Exports, ", "))
+	}
+	return fmt.Sprintf("module %s cannot run on wasm engine %s: %s: %v", e.Module, e.Engine, strings.Join(missing, "; "), errdefs.ErrFailedPrecondition)
+}
+
+// Cause allows errdefs to classify the error
+func (e *ModuleError) Cause() error {
+	return errdefs.ErrFailedPrecondition
+}
+
+// validateModule checks the imports and exports of the module against the
+// engine. Imports are only checked when the engine reported its features.
+func validateModule(s *Spec) error {
+	m, err := module.Open(s.Module)
+	if err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "failed to parse module %s: %v", s.ModulePath, err)
+	}
+	merr := &ModuleError{
+		Module: s.ModulePath,
+		Engine: s.Capabilities.Engine,
+	}
+	if len(s.Capabilities.Features) > 0 {
+		for _, imp := range m.Imports {
+			if !provides(s.Capabilities, imp) {
+				merr.Imports = append(merr.Imports, imp)
+			}
+		}
+	}
+	if !m.IsCommand() {
+		merr.Exports = append(merr.Exports, startExport)
+	}
+	if len(merr.Imports) > 0 || len(merr.Exports) > 0 {
+		return merr
+	}
+	return nil
+}
+
+// provides returns true if the engine satisfies the import
+func provides(caps *Capabilities, imp module.Import) bool {
+	if imp.Kind != module.KindFunc || !caps.Has(Feature(imp.Module)) {
+		return false
+	}
+	switch Feature(imp.Module) {
+	case FeatureWASIPreview1:
+		return wasiFunctions[imp.Name]
+	case FeatureWASIUnstable:
+		return wasiFunctions[imp.Name] && imp.Name != "sock_accept"
+	}
+	return false
+}
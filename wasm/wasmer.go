@@ -0,0 +1,97 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"os/exec"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	RegisterEngine(&wasmer{})
+}
+
+// wasmer runs modules with the wasmer command line
+type wasmer struct{}
+
+func (e *wasmer) Name() string {
+	return "wasmer"
+}
+
+func (e *wasmer) Prepare(ctx context.Context, s *Spec) error {
+	if len(s.Args) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no module to run")
+	}
+	return bindReadOnlyDirs(s)
+}
+
+func (e *wasmer) Compile(ctx context.Context, s *Spec, path string) error {
+	args := append(append([]string{"compile"}, s.engineArgs()...), s.Module, "-o", path)
+	return runCompile(ctx, s.binary("wasmer"), args...)
+}
+
+func (e *wasmer) Probe(ctx context.Context, s *Spec) (*Capabilities, error) {
+	binary := s.binary("wasmer")
+	version, err := commandVersion(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+	caps := &Capabilities{
+		Engine:   e.Name(),
+		Binary:   binary,
+		Version:  version,
+		Features: []Feature{FeatureWASIUnstable, FeatureWASIPreview1},
+	}
+	if canBindMount() {
+		caps.Features = append(caps.Features, FeatureReadOnlyDirs)
+	}
+	return caps, nil
+}
+
+func (e *wasmer) Start(ctx context.Context, s *Spec, stdio IO) (Instance, error) {
+	return startCommand(exec.Command(s.binary("wasmer"), e.args(s)...), s, stdio)
+}
+
+func (e *wasmer) command(s *Spec) []string {
+	return append([]string{s.binary("wasmer")}, e.args(s)...)
+}
+
+// args translates the spec into wasmer run flags:
+//
+//	wasmer [ENGINE ARGS] --mapdir=GUEST:HOST --env=KEY=value --command-name=ARGV0 MODULE ARGS...
+func (e *wasmer) args(s *Spec) []string {
+	args := append([]string(nil), s.engineArgs()...)
+	for _, d := range s.Dirs {
+		args = append(args, "--mapdir="+d.Guest+":"+d.Host)
+	}
+	// as with wasmtime, relative paths resolve against a directory mapped
+	// as "."
+	if s.Cwd != "" && s.Cwd != "/" {
+		if host, ok := s.hostPath(s.Cwd); ok {
+			args = append(args, "--mapdir=.:"+host)
+		}
+	}
+	for _, env := range s.Env {
+		args = append(args, "--env="+env)
+	}
+	// wasmer names the program after the path it runs unless given one
+	args = append(args, "--command-name="+s.Args[0], s.file())
+	return append(args, s.Args[1:]...)
+}
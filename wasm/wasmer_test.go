@@ -0,0 +1,108 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+)
+
+func TestWasmerArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec Spec
+		args []string
+	}{
+		{
+			name: "module",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+			},
+			args: []string{"--command-name=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "nested guest path",
+			spec: Spec{
+				Module: "/rootfs/usr/bin/app.wasm",
+				Args:   []string{"/usr/bin/app.wasm"},
+			},
+			args: []string{"--command-name=/usr/bin/app.wasm", "/rootfs/usr/bin/app.wasm"},
+		},
+		{
+			name: "args and env",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm", "-v", "input"},
+				Env:    []string{"A=b", "PATH=/bin:/usr/bin"},
+			},
+			args: []string{"--env=A=b", "--env=PATH=/bin:/usr/bin", "--command-name=/app.wasm", "/rootfs/app.wasm", "-v", "input"},
+		},
+		{
+			name: "engine args",
+			spec: Spec{
+				Module:  "/rootfs/app.wasm",
+				Args:    []string{"/app.wasm"},
+				Options: &options.Options{EngineArgs: []string{"--backend=llvm"}},
+			},
+			args: []string{"--backend=llvm", "--command-name=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "dirs",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Dirs: []Dir{
+					{Host: "/rootfs", Guest: "/"},
+					{Host: "/var/data", Guest: "/data"},
+				},
+			},
+			args: []string{"--mapdir=/:/rootfs", "--mapdir=/data:/var/data", "--command-name=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "cwd",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Cwd:    "/data/work",
+				Dirs: []Dir{
+					{Host: "/rootfs", Guest: "/"},
+					{Host: "/var/data", Guest: "/data"},
+				},
+			},
+			args: []string{"--mapdir=/:/rootfs", "--mapdir=/data:/var/data", "--mapdir=.:/var/data/work", "--command-name=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "precompiled",
+			spec: Spec{
+				Module:   "/rootfs/app.wasm",
+				Artifact: "/cache/artifact",
+				Args:     []string{"/app.wasm"},
+			},
+			args: []string{"--command-name=/app.wasm", "/cache/artifact"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := (&wasmer{}).args(&tc.spec)
+			if !reflect.DeepEqual(args, tc.args) {
+				t.Fatalf("expected args %q, got %q", tc.args, args)
+			}
+		})
+	}
+}
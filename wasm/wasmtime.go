@@ -0,0 +1,122 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+// wasmtimeMinVersion introduced the `--dir HOST::GUEST` syntax
+const wasmtimeMinVersion = "14.0.0"
+
+func init() {
+	RegisterEngine(&wasmtime{})
+}
+
+// wasmtime runs modules with the wasmtime command line
+type wasmtime struct{}
+
+func (e *wasmtime) Name() string {
+	return "wasmtime"
+}
+
+func (e *wasmtime) Prepare(ctx context.Context, s *Spec) error {
+	if len(s.Args) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no module to run")
+	}
+	if s.Cwd != "" {
+		if _, ok := s.hostPath(s.Cwd); !ok {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "working directory %s is not in a preopened directory", s.Cwd)
+		}
+	}
+	return bindReadOnlyDirs(s)
+}
+
+func (e *wasmtime) Probe(ctx context.Context, s *Spec) (*Capabilities, error) {
+	binary := s.binary("wasmtime")
+	version, err := commandVersion(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+	if !versionAtLeast(version, wasmtimeMinVersion) {
+		return nil, errors.Errorf("%s is version %s, at least %s is required", binary, version, wasmtimeMinVersion)
+	}
+	caps := &Capabilities{
+		Engine:   e.Name(),
+		Binary:   binary,
+		Version:  version,
+		Features: []Feature{FeatureWASIPreview1, FeatureMemoryLimit},
+	}
+	if canBindMount() {
+		caps.Features = append(caps.Features, FeatureReadOnlyDirs)
+	}
+	return caps, nil
+}
+
+func (e *wasmtime) Compile(ctx context.Context, s *Spec, path string) error {
+	args := append(append([]string{"compile"}, s.engineArgs()...), "-o", path, s.Module)
+	return runCompile(ctx, s.binary("wasmtime"), args...)
+}
+
+func (e *wasmtime) Start(ctx context.Context, s *Spec, stdio IO) (Instance, error) {
+	return startModuleCommand(exec.Command(s.binary("wasmtime"), e.args(s)...), s, stdio, wasmtimeMemoryErrors)
+}
+
+// wasmtimeMemoryErrors are printed by wasmtime when a module is over its
+// memory limit, when instantiated or trapped on growing its memory
+var wasmtimeMemoryErrors = []string{
+	"exceeds memory limits",
+	"forcing trap when growing memory",
+}
+
+func (e *wasmtime) command(s *Spec) []string {
+	return append([]string{s.binary("wasmtime")}, e.args(s)...)
+}
+
+// args translates the spec into wasmtime run flags:
+//
+//	wasmtime run [ENGINE ARGS] [-W max-memory-size=BYTES -W trap-on-grow-failure=y] --dir=HOST::GUEST --env=KEY=value [--allow-precompiled] --argv0=ARGV0 MODULE ARGS...
+func (e *wasmtime) args(s *Spec) []string {
+	args := append([]string{"run"}, s.engineArgs()...)
+	if s.MaxMemoryPages > 0 {
+		// trapping tells modules failing on the limit apart from others
+		args = append(args, "-W", "max-memory-size="+strconv.FormatUint(uint64(s.MaxMemoryPages)*wasmPageSize, 10), "-W", "trap-on-grow-failure=y")
+	}
+	for _, d := range s.Dirs {
+		args = append(args, "--dir="+d.Host+"::"+d.Guest)
+	}
+	// wasmtime has no notion of a working directory, instead wasi-libc
+	// resolves relative paths against a directory preopened as "."
+	if s.Cwd != "" && s.Cwd != "/" {
+		if host, ok := s.hostPath(s.Cwd); ok {
+			args = append(args, "--dir="+host+"::.")
+		}
+	}
+	for _, env := range s.Env {
+		args = append(args, "--env="+env)
+	}
+	if s.Artifact != "" {
+		args = append(args, "--allow-precompiled")
+	}
+	args = append(args, "--argv0="+s.Args[0], s.file())
+	return append(args, s.Args[1:]...)
+}
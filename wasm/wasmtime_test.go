@@ -0,0 +1,132 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dmcgowan/containerd-wasm/wasm/options"
+)
+
+func TestWasmtimeArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		spec Spec
+		args []string
+	}{
+		{
+			name: "module",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+			},
+			args: []string{"run", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "args and env",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm", "-v", "input"},
+				Env:    []string{"A=b", "PATH=/bin:/usr/bin"},
+			},
+			args: []string{"run", "--env=A=b", "--env=PATH=/bin:/usr/bin", "--argv0=/app.wasm", "/rootfs/app.wasm", "-v", "input"},
+		},
+		{
+			name: "engine args",
+			spec: Spec{
+				Module:  "/rootfs/app.wasm",
+				Args:    []string{"/app.wasm"},
+				Options: &options.Options{EngineArgs: []string{"-O", "opt-level=2"}},
+			},
+			args: []string{"run", "-O", "opt-level=2", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "dirs",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Dirs: []Dir{
+					{Host: "/rootfs", Guest: "/"},
+					{Host: "/var/data", Guest: "/data"},
+				},
+			},
+			args: []string{"run", "--dir=/rootfs::/", "--dir=/var/data::/data", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			// Prepare replaced the host of read-only dirs with a bind mount
+			name: "read-only bind",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Dirs: []Dir{
+					{Host: "/bundle/preopens/0", Guest: "/", ReadOnly: true},
+					{Host: "/var/data", Guest: "/data"},
+				},
+			},
+			args: []string{"run", "--dir=/bundle/preopens/0::/", "--dir=/var/data::/data", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "cwd",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Cwd:    "/data/work",
+				Dirs: []Dir{
+					{Host: "/rootfs", Guest: "/"},
+					{Host: "/var/data", Guest: "/data"},
+				},
+			},
+			args: []string{"run", "--dir=/rootfs::/", "--dir=/var/data::/data", "--dir=/var/data/work::.", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "root cwd",
+			spec: Spec{
+				Module: "/rootfs/app.wasm",
+				Args:   []string{"/app.wasm"},
+				Cwd:    "/",
+				Dirs:   []Dir{{Host: "/rootfs", Guest: "/"}},
+			},
+			args: []string{"run", "--dir=/rootfs::/", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+		{
+			name: "precompiled",
+			spec: Spec{
+				Module:   "/rootfs/app.wasm",
+				Artifact: "/cache/artifact",
+				Args:     []string{"/app.wasm"},
+			},
+			args: []string{"run", "--allow-precompiled", "--argv0=/app.wasm", "/cache/artifact"},
+		},
+		{
+			name: "max memory",
+			spec: Spec{
+				Module:         "/rootfs/app.wasm",
+				Args:           []string{"/app.wasm"},
+				MaxMemoryPages: 16,
+			},
+			args: []string{"run", "-W", "max-memory-size=1048576", "-W", "trap-on-grow-failure=y", "--argv0=/app.wasm", "/rootfs/app.wasm"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := (&wasmtime{}).args(&tc.spec)
+			if !reflect.DeepEqual(args, tc.args) {
+				t.Fatalf("expected args %q, got %q", tc.args, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+
+	v1 "github.com/containerd/cgroups/stats/v1"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// trapExitStatus is reported when a module traps, mirroring a native
+// process that aborted
+const trapExitStatus = 128 + int(syscall.SIGABRT)
+
+func init() {
+	RegisterEngine(&wazeroEngine{})
+}
+
+// wazeroEngine runs modules inside the shim process with the wazero runtime
+type wazeroEngine struct{}
+
+func (e *wazeroEngine) Name() string {
+	return "wazero"
+}
+
+func (e *wazeroEngine) Prepare(ctx context.Context, s *Spec) error {
+	if len(s.Args) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no module to run")
+	}
+	return nil
+}
+
+func (e *wazeroEngine) Probe(ctx context.Context, s *Spec) (*Capabilities, error) {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/tetratelabs/wazero" {
+				version = strings.TrimPrefix(dep.Version, "v")
+			}
+		}
+	}
+	return &Capabilities{
+		Engine:   e.Name(),
+		Version:  version,
+		Features: []Feature{FeatureWASIPreview1, FeatureReadOnlyDirs, FeatureMemoryLimit},
+	}, nil
+}
+
+func (e *wazeroEngine) Start(ctx context.Context, s *Spec, stdio IO) (_ Instance, err error) {
+	bin, err := ioutil.ReadFile(s.Module)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read module")
+	}
+
+	// the instance outlives the request that started it
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	defer func() {
+		if err != nil {
+			rt.Close(ctx)
+			cancel()
+		}
+	}()
+	if err := instantiateWASI(ctx, rt); err != nil {
+		return nil, err
+	}
+	compiled, err := rt.CompileModule(ctx, bin)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile module")
+	}
+	ictx := ctx
+	if s.MaxMemoryPages > 0 {
+		ictx = experimental.WithMemoryAllocator(ctx, memoryLimiter{limit: uint64(s.MaxMemoryPages) * wasmPageSize})
+	}
+	mod, err := rt.InstantiateModule(ictx, compiled, e.config(s, stdio))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to instantiate module")
+	}
+	start := mod.ExportedFunction("_start")
+	if start == nil {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "module does not export _start")
+	}
+
+	i := &wazeroInstance{
+		rt:     rt,
+		mod:    mod,
+		cancel: cancel,
+		exited: make(chan struct{}),
+	}
+	go i.run(ctx, start)
+	return i, nil
+}
+
+// instantiateWASI provides wasi_snapshot_preview1 to modules. wasi_unstable
+// is not provided as its ABI differs, such modules are rejected on create.
+func instantiateWASI(ctx context.Context, rt wazero.Runtime) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		return errors.Wrap(err, "failed to instantiate wasi")
+	}
+	return nil
+}
+
+func (e *wazeroEngine) config(s *Spec, stdio IO) wazero.ModuleConfig {
+	var dirs []Dir
+	// wazero mounts "." over "/", so rather than at "." as wasmer and
+	// wasmtime do, the working directory is preopened first at its guest
+	// path. Guests taking the first preopen as their working directory,
+	// such as Zig's, resolve relative paths in it.
+	if s.Cwd != "" && s.Cwd != "/" {
+		if d, rel, ok := s.preopen(s.Cwd); ok {
+			dirs = append(dirs, Dir{Host: filepath.Join(d.Host, rel), Guest: s.Cwd, ReadOnly: d.ReadOnly})
+		}
+	}
+	fs := wazero.NewFSConfig()
+	for _, d := range append(dirs, s.Dirs...) {
+		if d.ReadOnly {
+			fs = fs.WithReadOnlyDirMount(d.Host, d.Guest)
+		} else {
+			fs = fs.WithDirMount(d.Host, d.Guest)
+		}
+	}
+	config := wazero.NewModuleConfig().
+		WithName(s.ID).
+		WithArgs(s.Args...).
+		WithFSConfig(fs).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader).
+		// _start is called by the instance so that it can be stopped
+		WithStartFunctions()
+	for _, env := range s.Env {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) == 2 {
+			config = config.WithEnv(kv[0], kv[1])
+		}
+	}
+	if stdio.Stdin != nil {
+		config = config.WithStdin(stdio.Stdin)
+	}
+	if stdio.Stdout != nil {
+		config = config.WithStdout(stdio.Stdout)
+	}
+	if stdio.Stderr != nil {
+		config = config.WithStderr(stdio.Stderr)
+	}
+	return config
+}
+
+// wazeroInstance is a module running on a goroutine of the shim
+type wazeroInstance struct {
+	mu sync.Mutex
+
+	rt     wazero.Runtime
+	mod    api.Module
+	cancel func()
+	exited chan struct{}
+	status int
+	reason string
+	signal syscall.Signal
+}
+
+func (i *wazeroInstance) run(ctx context.Context, start api.Function) {
+	_, err := start.Call(ctx)
+
+	i.mu.Lock()
+	switch {
+	case i.signal != 0:
+		i.status = 128 + int(i.signal)
+	case err == nil:
+		i.status = 0
+	default:
+		if exitErr, ok := err.(*sys.ExitError); ok {
+			i.status = int(exitErr.ExitCode())
+		} else {
+			logrus.WithError(err).Warn("wasm module trapped")
+			i.status = trapExitStatus
+			if errors.Is(err, errMemoryLimit) {
+				i.reason = ExitReasonMemoryExhausted
+			}
+		}
+	}
+	i.mu.Unlock()
+
+	i.rt.Close(ctx)
+	i.cancel()
+	close(i.exited)
+}
+
+// Pid of the shim, which hosts the instance
+func (i *wazeroInstance) Pid() int {
+	return os.Getpid()
+}
+
+func (i *wazeroInstance) Signal(sig syscall.Signal) error {
+	switch sig {
+	case 0, syscall.SIGCHLD, syscall.SIGURG, syscall.SIGWINCH:
+		// no-op or ignored by default
+		return nil
+	case syscall.SIGSTOP, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGCONT:
+		return errors.Wrapf(errdefs.ErrNotImplemented, "signal %v for in-process instances", sig)
+	}
+	i.mu.Lock()
+	if i.signal == 0 {
+		i.signal = sig
+	}
+	i.mu.Unlock()
+	// closes the module with the context
+	i.cancel()
+	return nil
+}
+
+func (i *wazeroInstance) Wait() (int, error) {
+	<-i.exited
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.status, nil
+}
+
+func (i *wazeroInstance) ExitReason() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.reason
+}
+
+// errMemoryLimit traps modules growing their linear memory past the maximum
+// of the spec
+var errMemoryLimit = errors.New("linear memory limit exceeded")
+
+// memoryLimiter allocates linear memories trapping when grown past limit
+// bytes. wazero refuses grows past the maximum of a memory before they
+// reach the allocator, so rather than lowering the maximum the limit is
+// enforced here, where the grow exhausting it is seen.
+type memoryLimiter struct {
+	limit uint64
+}
+
+func (l memoryLimiter) Allocate(cap, max uint64) experimental.LinearMemory {
+	return &limitedMemory{buf: make([]byte, 0, cap), limit: l.limit}
+}
+
+// limitedMemory is a linear memory of at most limit bytes
+type limitedMemory struct {
+	buf   []byte
+	limit uint64
+}
+
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.limit {
+		// recovered by wazero, which fails the call with it
+		panic(errors.Wrapf(errMemoryLimit, "growing to %d bytes over %d", size, m.limit))
+	}
+	if n := uint64(len(m.buf)); size > uint64(cap(m.buf)) {
+		m.buf = append(m.buf, make([]byte, size-n)...)
+	} else {
+		m.buf = m.buf[:size]
+	}
+	return m.buf
+}
+
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
+
+func (i *wazeroInstance) Stats(ctx context.Context) (interface{}, error) {
+	var usage uint64
+	select {
+	case <-i.exited:
+	default:
+		if mem := i.mod.Memory(); mem != nil {
+			usage = uint64(mem.Size())
+		}
+	}
+	return &v1.Metrics{
+		Memory: &v1.MemoryStat{
+			Usage: &v1.MemoryEntry{
+				Usage: usage,
+			},
+		},
+	}, nil
+}